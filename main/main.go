@@ -8,6 +8,7 @@ import (
 	"go_ping/show"
 	"go_ping/task"
 	"go_ping/utils"
+	"net"
 	"os"
 	"os/signal"
 	"runtime"
@@ -18,19 +19,57 @@ import (
 
 // 定义命令行参数对应的变量
 var (
-	version      = flag.BoolP("version", "V", false, "show version")
-	dstTarget    = flag.StringP("dst.target", "d", "", "打流目的目标，可以填写目标IP/域名/网段\n和文件互斥，使用文件就无需使用此参数")
-	dstPort      = flag.IntP("dst.port", "p", utils.DefaultPortNumber, "打流目的端口，取值[1~65535)")
-	dstFile      = flag.StringP("dst.file", "f", "", "指定存放目的信息的文件路径，文件内容每行的格式：\n如果是tcp打流(IP PORT)：1.1.1.1 80 或者 1.1.1.0/24 80\n如果是icmp打流：1.1.1.1 或者 1.1.1.0/24\n如果是http打流(域名不能以http开头)：1.1.1.1 80 或者 1.1.1.0/24 80 或者 taobao.com 80")
-	dstFileLoose = flag.BoolP("dst.file.loose", "L", false, "文件格式校验模式，此参数可打开宽松模式，默认严格模式\n严格模式：TCP和HTTP打流 文件内必须包含端口信息，ICMP不能包含端口信息\n宽松模式：系统会根据-p参数自动加上或去掉端口信息")
-	srcIp        = flag.StringP("src.ip", "s", "", "指定源IP")
-	pingType     = flag.StringP("ping.type", "t", "tcp", "打流类型，取值[tcp,icmp,http]\nicmp打流需要使用root权限")
-	timeout      = flag.IntP("ping.timeout", "m", 1, "设置超时时间，单位秒，取值[1~10]")
-	concurrency  = flag.IntP("ping.concurrency", "c", 11, "设置总并发数，取值[1~100)")
-	number       = flag.IntP("ping.number", "n", 100, "指定每个IP或域名的打流次数，取值[0~100000]，0表示持续打流 即不指定打流次数")
-	showMode     = flag.StringP("show.mode", "o", "table", "指定展示模式，取值：\ntable：表格输出，持续打流模式只能表格输出\nwaterfall：瀑布展示，即一行一行日志输出\njson：json格式，适用于对接系统")
-	domainA      = flag.BoolP("domain.a", "a", false, "打流域名下解析的A记录，打流结合-d和-p使用")
-	logLevel     = flag.StringP("log.level", "l", "info", "设置日志级别，debug/info/warn/error，日志输出到/tmp/go_ping.log")
+	version             = flag.BoolP("version", "V", false, "show version")
+	dstTarget           = flag.StringP("dst.target", "d", "", "打流目的目标，可以填写目标IP/域名/网段，http打流还可以填写http://或https://开头的url\n和文件互斥，使用文件就无需使用此参数")
+	dstPort             = flag.IntP("dst.port", "p", utils.DefaultPortNumber, "打流目的端口，取值[1~65535)")
+	dstFile             = flag.StringP("dst.file", "f", "", "指定存放目的信息的文件路径，文件内容每行的格式：\n如果是tcp/udp打流(IP PORT)：1.1.1.1 80 或者 1.1.1.0/24 80\n如果是icmp打流：1.1.1.1 或者 1.1.1.0/24\n如果是dns打流(IP [PORT])：1.1.1.1 53 或者 1.1.1.1，省略端口时使用-p，默认53\n如果是tls打流(IP [PORT])：1.1.1.1 443 或者 1.1.1.0/24，省略端口时使用-p，默认443\n如果是trace逐跳探测：同icmp，--trace.proto为tcp时同tcp\n如果是http打流：1.1.1.1 80 或者 1.1.1.0/24 80 或者 taobao.com 80 或者 https://taobao.com/healthz?a=1")
+	dstFileLoose        = flag.BoolP("dst.file.loose", "L", false, "文件格式校验模式，此参数可打开宽松模式，默认严格模式\n严格模式：TCP、UDP和HTTP打流 文件内必须包含端口信息，DNS、TLS可以省略端口，ICMP不能包含端口信息\n宽松模式：系统会根据-p参数自动加上或去掉端口信息")
+	srcIp               = flag.StringP("src.ip", "s", "", "指定源IP")
+	pingType            = flag.StringP("ping.type", "t", "tcp", "打流类型，取值[tcp,icmp,http,udp,dns,tls,tcp-syn,trace]\nicmp打流没有root权限时使用非特权的icmp套接字，见--icmp.sock\nudp打流收到回复表示端口开放，收到icmp端口不可达表示端口关闭，没有任何回复表示被过滤\ndns打流的目标是DNS服务器，需要结合--dns.name使用，没有指定-p时端口为53\ntls打流进行TLS握手并校验服务端证书，没有指定-p时端口为443\ntcp-syn打流使用原始套接字只发SYN，收到SYN-ACK表示端口开放，收到RST表示端口关闭，没有任何回复表示被过滤，需要使用root权限，只支持Linux\ntrace为逐跳探测（traceroute），按TTL从1开始递增发包，输出每一跳回复的地址和时延，协议见--trace.proto，需要使用root权限，只支持Linux")
+	timeout             = flag.IntP("ping.timeout", "m", 1, "设置超时时间，单位秒，取值[1~10]")
+	concurrency         = flag.IntP("ping.concurrency", "c", 11, "设置总并发数，取值[1~100)")
+	number              = flag.IntP("ping.number", "n", 100, "指定每个IP或域名的打流次数，取值[0~100000]，0表示持续打流 即不指定打流次数\ntrace逐跳探测不区分打流次数，0表示持续逐跳探测，统计每一跳的丢包和时延，类似mtr")
+	showMode            = flag.StringP("show.mode", "o", "table", "指定展示模式，取值：\ntable：表格输出\nwaterfall：瀑布展示，即一行一行日志输出，持续打流模式下按表格输出\njson：json格式，适用于对接系统，持续打流模式每一轮输出一行\nndjson：每个探测结果输出一行json，适用于流式对接jq、日志采集")
+	showTop             = flag.IntP("show.top", "T", 0, "表格输出时只展示失败占比最高的前N个目标，取值[0~100000]，0表示展示所有目标")
+	tcpSend             = flag.String("tcp.send", "", "tcp打流建连后发送的内容，以hex:开头表示十六进制，否则支持\\r、\\n、\\t、\\xNN转义，比如PING\\r\\n")
+	tcpExpect           = flag.String("tcp.expect", "", "tcp打流响应需要包含的内容，格式同--tcp.send，比如+PONG，收到符合预期的响应才算成功")
+	tcpExpectRegex      = flag.String("tcp.expect.regex", "", "tcp打流响应需要匹配的正则，比如^SSH-2.0-、^220 ")
+	tcpReadTimeout      = flag.Int("tcp.read.timeout", 0, "tcp打流等待响应的超时时间，单位毫秒，取值[0~10000]，0表示使用-m的超时时间")
+	tcpCloseRst         = flag.Bool("tcp.close.rst", false, "tcp打流使用RST关闭连接（SO_LINGER 0），不产生TIME_WAIT，高并发大量打流时避免源端口耗尽")
+	icmpSize            = flag.Int("icmp.size", 0, "icmp打流发包内容的字节数（不包含icmp头），取值0或[33~65507]，0表示只发送8字节发送时间戳、2字节ID、4字节进程随机数、4字节轮次和15字节固定内容\n测试MTU时结合--icmp.df使用，比如ipv4的1500字节MTU对应1472")
+	icmpPattern         = flag.String("icmp.pattern", "", "icmp打流循环填充到--icmp.size字节的内容，格式同--tcp.send，比如hex:ff00，默认填充0")
+	icmpTtl             = flag.Int("icmp.ttl", 0, "icmp打流的TTL（ipv6为hop limit），取值[0~255]，0表示使用系统默认值，可以测试N跳以内是否可达")
+	icmpDf              = flag.Bool("icmp.df", false, "icmp打流设置不分片（DF），超过路径MTU的包会被丢弃而不是分片")
+	icmpSock            = flag.String("icmp.sock", "auto", "icmp打流使用的套接字，取值：\nauto：root权限使用原始套接字，否则使用非特权的icmp套接字\nraw：原始套接字，需要root权限\ndgram：非特权的icmp套接字，不需要root权限，Linux需要net.ipv4.ping_group_range包含当前用户组")
+	icmpTos             = flag.Int("icmp.tos", 0, "icmp打流的TOS（ipv6为traffic class），取值[0~255]，比如DSCP EF对应184(0xb8)，0表示使用系统默认值")
+	traceProto          = flag.String("trace.proto", "icmp", "逐跳探测使用的协议，取值：\nicmp：icmp echo请求\nudp：udp发往33434开始的高端口，目标回复端口不可达表示到达\ntcp：tcp SYN发往-p指定的端口，目标回复SYN-ACK或者RST表示到达")
+	traceMaxHops        = flag.Int("trace.max.hops", 30, "逐跳探测的最大跳数，取值[1~255]")
+	traceQueries        = flag.Int("trace.queries", 3, "逐跳探测每一跳的探测次数，取值[1~10]")
+	udpPayload          = flag.String("udp.payload", utils.DefaultUdpPayload, "udp打流的发包内容，以hex:开头表示十六进制，比如hex:0a0b")
+	udpSilenceOk        = flag.Bool("udp.silence.ok", false, "udp打流没有任何回复时也算成功，适用于syslog这种不回复的服务")
+	dnsName             = flag.String("dns.name", "", "dns打流查询的域名")
+	dnsType             = flag.String("dns.type", "A", "dns打流查询的记录类型，取值[A,AAAA,CNAME,MX,TXT,SRV]")
+	dnsProto            = flag.String("dns.proto", "udp", "dns打流查询使用的协议，取值[udp,tcp]")
+	dnsExpect           = flag.String("dns.expect", "", "dns打流期望的应答记录，多个用逗号分隔，每个都要出现在应答里，否则算失败\n比如A记录：1.1.1.1,2.2.2.2，MX记录：10 mx.example.com")
+	httpScheme          = flag.String("http.scheme", "http", "http打流的目标不是url时使用的协议，取值[http,https]")
+	tlsSkipVerify       = flag.Bool("tls.skip.verify", false, "https/tls打流不校验服务端证书，tls打流证书过期仍然算失败")
+	tlsCaFile           = flag.String("tls.ca.file", "", "https/tls打流校验服务端证书使用的CA证书文件（PEM格式），默认使用系统的CA证书")
+	tlsSni              = flag.String("tls.sni", "", "https/tls打流TLS握手时使用的服务器名称（SNI），默认使用url里的域名或者目标域名")
+	tlsWarnDays         = flag.Int("tls.warn.days", 30, "tls打流证书在N天内过期时告警，不算失败，取值[0~3650]，0表示不告警")
+	httpMethod          = flag.String("http.method", "GET", "http打流的请求方法，取值[GET,HEAD,POST,PUT,PATCH,DELETE,OPTIONS]")
+	httpRequestHeader   = flag.String("http.request.header", "", "http打流请求需要带上的头，多个用逗号分隔，格式为Name:Value\nValue里的$VAR或${VAR}会替换为环境变量的值，适合传递密钥，比如Authorization:${TOKEN}")
+	httpRequestBodyFile = flag.String("http.request.body.file", "", "http打流的请求体文件路径")
+	httpHost            = flag.String("http.host", "", "http打流请求的Host头，默认使用url里的域名，https打流没有指定--tls.sni时也作为SNI\n适用于直接打流后端IP，同时带上线上的域名")
+	httpRedirect        = flag.String("http.redirect", "follow", "http打流的跳转策略，取值：\nfollow：跟随跳转，超过--http.redirect.max次算失败\nnone：不跟随跳转，以跳转的响应作为最终响应进行校验\nfail：收到跳转的响应算失败")
+	httpRedirectMax     = flag.Int("http.redirect.max", 10, "http打流跟随跳转时最多跳转的次数，取值[1~100]")
+	httpConn            = flag.String("http.conn", "new", "http打流的连接模式，取值：\nnew：每次探测新建连接，时延包含完整的建连耗时\nkeepalive：每个协程复用长连接，时延为稳定状态下的应用时延")
+	httpStatus          = flag.String("http.status", "", "http打流允许的状态码，多个用逗号分隔，支持200、200-299、2xx三种格式，默认任何状态码都算成功")
+	httpBody            = flag.String("http.body", "", "http打流响应体需要包含的字符串")
+	httpBodyRegex       = flag.String("http.body.regex", "", "http打流响应体需要匹配的正则")
+	httpHeader          = flag.String("http.header", "", "http打流响应需要包含的头，多个用逗号分隔，格式为Name或者Name:Value")
+	httpBodyMax         = flag.String("http.body.max", "", "http打流响应体的最大字节数，超过算失败")
+	domainA             = flag.BoolP("domain.a", "a", false, "打流域名下解析的A记录，打流结合-d和-p使用")
+	logLevel            = flag.StringP("log.level", "l", "info", "设置日志级别，debug/info/warn/error，日志输出到/tmp/go_ping.log")
 )
 
 var wg sync.WaitGroup
@@ -39,7 +78,7 @@ func main() {
 	//创建监听退出chan
 	ctx, cancel := context.WithCancel(context.Background())
 	//初始化信号
-	chSignal := make(chan os.Signal)
+	chSignal := make(chan os.Signal, 1)
 	//监听指定信号 ctrl+c kill
 	signal.Notify(chSignal, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGKILL)
 	go func() {
@@ -66,21 +105,80 @@ func main() {
 	})
 	// 收集参数，待后面使用
 	paramInput := task.ParamInput{
-		DstTarget:    *dstTarget,
-		DstPort:      *dstPort,
-		DstFile:      *dstFile,
-		DstFileLoose: *dstFileLoose,
-		SrcIp:        *srcIp,
-		PingType:     *pingType,
-		Timeout:      *timeout,
-		Concurrency:  *concurrency,
-		Number:       *number,
-		ShowMode:     *showMode,
-		LogLevel:     *logLevel,
-		DomainA:      *domainA,
+		DstTarget:           *dstTarget,
+		DstPort:             *dstPort,
+		DstFile:             *dstFile,
+		DstFileLoose:        *dstFileLoose,
+		SrcIp:               *srcIp,
+		PingType:            *pingType,
+		Timeout:             *timeout,
+		Concurrency:         *concurrency,
+		Number:              *number,
+		ShowMode:            *showMode,
+		LogLevel:            *logLevel,
+		DomainA:             *domainA,
+		ShowTop:             *showTop,
+		TcpSend:             *tcpSend,
+		TcpExpect:           *tcpExpect,
+		TcpExpectRegex:      *tcpExpectRegex,
+		TcpReadTimeout:      *tcpReadTimeout,
+		TcpCloseRst:         *tcpCloseRst,
+		UdpPayload:          *udpPayload,
+		UdpSilenceOk:        *udpSilenceOk,
+		DnsName:             *dnsName,
+		DnsType:             *dnsType,
+		DnsProto:            *dnsProto,
+		DnsExpect:           *dnsExpect,
+		HttpScheme:          *httpScheme,
+		TlsSkipVerify:       *tlsSkipVerify,
+		TlsWarnDays:         *tlsWarnDays,
+		TlsCaFile:           *tlsCaFile,
+		TlsSni:              *tlsSni,
+		HttpMethod:          *httpMethod,
+		HttpRequestHeader:   *httpRequestHeader,
+		HttpRequestBodyFile: *httpRequestBodyFile,
+		HttpHost:            *httpHost,
+		HttpRedirect:        *httpRedirect,
+		HttpRedirectMax:     *httpRedirectMax,
+		HttpConn:            *httpConn,
+		HttpStatus:          *httpStatus,
+		HttpBody:            *httpBody,
+		HttpBodyRegex:       *httpBodyRegex,
+		HttpHeader:          *httpHeader,
+		HttpBodyMax:         *httpBodyMax,
+		IcmpSize:            *icmpSize,
+		IcmpPattern:         *icmpPattern,
+		IcmpTtl:             *icmpTtl,
+		IcmpDf:              *icmpDf,
+		IcmpTos:             *icmpTos,
+		IcmpSock:            *icmpSock,
+		TraceProto:          *traceProto,
+		TraceMaxHops:        *traceMaxHops,
+		TraceQueries:        *traceQueries,
 	}
 	// 校验参数
 	utils.ValidateParams(params)
+	// 校验TLS配置
+	if _, err := ping.NewTlsConfig(*tlsSkipVerify, *tlsCaFile, *tlsSni); err != nil {
+		fmt.Println("TLS配置错误：", err)
+		os.Exit(0)
+	}
+	// dns打流需要指定查询的域名，没有指定端口时使用53端口
+	if *pingType == utils.PingTypeDNS {
+		if *dnsName == "" {
+			fmt.Println("dns打流需要指定查询的域名")
+			os.Exit(0)
+		}
+		if _, ok := params["dst.port"]; !ok {
+			paramInput.DstPort = utils.DefaultDnsPort
+		}
+	}
+	// tls打流没有指定端口时使用443端口
+	if *pingType == utils.PingTypeTLS {
+		if _, ok := params["dst.port"]; !ok {
+			paramInput.DstPort = utils.DefaultTlsPort
+		}
+	}
 	// 设置日志级别
 	utils.SetLogLevel(*logLevel)
 	// 软件版本
@@ -91,24 +189,72 @@ func main() {
 	fr := task.NewFailRate()
 	// 文件行数统计
 	fl := task.FileTaskItemNumber{}
-	if *showMode == utils.ShowModeJson {
-		fmt.Println("目前还不支持JSON格式输出，敬请期待...")
-		os.Exit(0)
+	// icmp没有root权限时使用非特权的icmp套接字
+	if *pingType == utils.PingTypeICMP && paramInput.IcmpSock == utils.IcmpSockAuto {
+		paramInput.IcmpSock = utils.IcmpSockRaw
+		if runtime.GOOS != "windows" && os.Getuid() != 0 {
+			paramInput.IcmpSock = utils.IcmpSockDgram
+		}
 	}
-	// icmp要以root权限发包
-	if *pingType == utils.PingTypeICMP && runtime.GOOS != "windows" && os.Getuid() != 0 {
+	// icmp原始套接字、tcp-syn、逐跳探测要以root权限发包
+	if ((*pingType == utils.PingTypeICMP && paramInput.IcmpSock == utils.IcmpSockRaw) || *pingType == utils.PingTypeTcpSyn || *pingType == utils.PingTypeTrace) && runtime.GOOS != "windows" && os.Getuid() != 0 {
 		fmt.Println("请以root(sudo)权限运行！")
 		os.Exit(0)
 	}
-	if *pingType == utils.PingTypeTCP || *pingType == utils.PingTypeHTTP {
+	// 只有Linux会把tcp报文交给原始套接字
+	if *pingType == utils.PingTypeTcpSyn && runtime.GOOS != "linux" {
+		fmt.Println("tcp-syn打流只支持Linux")
+		os.Exit(0)
+	}
+	// 逐跳探测通过控制消息给每个包单独设置TTL，只支持Linux
+	if *pingType == utils.PingTypeTrace && runtime.GOOS != "linux" {
+		fmt.Println("逐跳探测只支持Linux")
+		os.Exit(0)
+	}
+	// 逐跳探测不区分打流次数，所有目标探测完成后退出
+	if *pingType == utils.PingTypeTrace {
+		// 复用icmp打流的原始套接字，路由器返回的TTL超时、目标不可达都是icmp报文
+		handle, _ := ping.GenSendHandle(paramInput.SrcIp, false)
+		handleV6, _ := ping.GenSendHandleV6(paramInput.SrcIp, false)
+		defer handle.Close()
+		defer handleV6.Close()
+		var tcpHandle, tcpHandleV6 *net.IPConn
+		if paramInput.TraceProto == utils.TraceProtoTcp {
+			tcpHandle, _ = ping.GenTcpSynHandle(paramInput.SrcIp)
+			tcpHandleV6, _ = ping.GenTcpSynHandleV6(paramInput.SrcIp)
+			defer tcpHandle.Close()
+			defer tcpHandleV6.Close()
+		}
+		task.TaskScheduleTrace(paramInput, ctx, handle, handleV6, tcpHandle, tcpHandleV6)
+		cancel()
+		if *showMode != utils.ShowModeJson && *showMode != utils.ShowModeNdjson {
+			fmt.Println("总共花费时间：", time.Since(startTime))
+		}
+		return
+	}
+	if *pingType == utils.PingTypeTCP || *pingType == utils.PingTypeHTTP || *pingType == utils.PingTypeUDP || *pingType == utils.PingTypeDNS || *pingType == utils.PingTypeTLS {
 		task.TaskSchedule(paramInput, &wg, fr, ctx, &fl)
 	} else if *pingType == utils.PingTypeICMP {
 		// 构建conn
-		handle, _ := ping.GenSendHandle(paramInput.SrcIp)
-		handleV6, _ := ping.GenSendHandleV6(paramInput.SrcIp)
+		handle, _ := ping.GenSendHandle(paramInput.SrcIp, paramInput.IcmpSock == utils.IcmpSockDgram)
+		handleV6, _ := ping.GenSendHandleV6(paramInput.SrcIp, paramInput.IcmpSock == utils.IcmpSockDgram)
 		defer handle.Close()
 		defer handleV6.Close()
+		// 设置发包选项，记录实际生效的值用于展示
+		icmpOption := task.GenIcmpOption(paramInput)
+		if err := icmpOption.Apply(handle, handleV6); err != nil {
+			fmt.Println("icmp发包选项错误：", err)
+			os.Exit(0)
+		}
+		paramInput.IcmpEffective = icmpOption.Effective(handle, handleV6)
 		task.TaskScheduleICMP(paramInput, &wg, fr, ctx, handle, handleV6, &fl)
+	} else if *pingType == utils.PingTypeTcpSyn {
+		// 构建原始套接字
+		handle, _ := ping.GenTcpSynHandle(paramInput.SrcIp)
+		handleV6, _ := ping.GenTcpSynHandleV6(paramInput.SrcIp)
+		defer handle.Close()
+		defer handleV6.Close()
+		task.TaskScheduleTcpSyn(paramInput, &wg, fr, ctx, handle, handleV6, &fl)
 	}
 	// 如果用户指定发包数
 	if *number != 0 {
@@ -116,11 +262,24 @@ func main() {
 		if *showMode == utils.ShowModeTable {
 			go task.ShowTableLoop(ctx, fr, taskNum, paramInput)
 		}
-		wg.Wait()               // 等待所有登记的goroutine都结束
-		time.Sleep(time.Second) // 等待表格再刷最后一遍，防止显示半个表格
-		cancel()                // 关闭通道，向所有 goroutine 发送停止信号
+		wg.Wait() // 等待所有登记的goroutine都结束
+		if *showMode == utils.ShowModeJson {
+			task.ShowJson(fr, &fl, paramInput, startTime)
+		} else if *showMode == utils.ShowModeWaterfall {
+			task.ShowWaterfallSummary(fr, paramInput)
+		} else {
+			time.Sleep(time.Second) // 等待表格再刷最后一遍，防止显示半个表格
+		}
+		cancel() // 关闭通道，向所有 goroutine 发送停止信号
 	}
 	// 等待其他goroutine清理现场
 	time.Sleep(time.Second)
-	fmt.Println("总共花费时间：", time.Since(startTime))
+	// 表格展示时，最后输出http打流的跳转链
+	if *number != 0 && *showMode == utils.ShowModeTable {
+		task.ShowRedirectSummary(fr)
+	}
+	// json/ndjson输出时，标准输出只保留json内容
+	if *showMode != utils.ShowModeJson && *showMode != utils.ShowModeNdjson {
+		fmt.Println("总共花费时间：", time.Since(startTime))
+	}
 }
@@ -0,0 +1,259 @@
+package ping
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go_ping/utils"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DnsTypeMap dns打流支持的记录类型
+var DnsTypeMap = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"TXT":   dnsmessage.TypeTXT,
+	"SRV":   dnsmessage.TypeSRV,
+}
+
+// DnsResult dns打流的应答
+type DnsResult struct {
+	Rcode   string   // 应答码，比如NOERROR、NXDOMAIN、SERVFAIL
+	Answers []string // 应答记录的值，排序后的
+}
+
+// DnsPing dns ping原子函数，每个goroutines执行的
+// 向目标DNS服务器查询name的qtype记录，应答码不是NOERROR算失败；
+// 指定了expect时，expect里的每个值都要出现在应答记录里，否则算失败；udp应答被截断时改用tcp重新查询
+func DnsPing(dstIpOrDomain string, dstPort int, timeout int, srcIp string, name string, qtype string, proto string, expect []string) PingResult {
+	// 目标地址
+	dstAddress := net.JoinHostPort(dstIpOrDomain, fmt.Sprintf("%d", dstPort))
+	// 指定超时时间
+	if timeout <= 0 {
+		timeout = 1
+	}
+	duration := time.Duration(timeout) * time.Second
+	d, err := dnsDialer(srcIp, proto, duration)
+	if err != nil {
+		utils.Log.Errorln(err)
+		return failResult(err)
+	}
+	// 构造查询
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	queryName, err := dnsmessage.NewName(name)
+	if err != nil {
+		utils.Log.Errorln(err)
+		return failResult(err)
+	}
+	queryId := uint16(rand.Intn(65536))
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: queryId, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  queryName,
+			Type:  DnsTypeMap[qtype],
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		utils.Log.Errorln(err)
+		return failResult(err)
+	}
+	startTime := time.Now()
+	deadline := startTime.Add(duration)
+	response, err := dnsQuery(d, proto, dstAddress, packed, queryId, deadline)
+	if err != nil {
+		utils.Log.Traceln(err)
+		return dnsFailResult(err, "")
+	}
+	// udp应答被截断（TC位）时，同dig一样改用tcp重新查询，时延包含两次查询
+	if proto != "tcp" && response.Truncated {
+		tcpDialer, err := dnsDialer(srcIp, "tcp", duration)
+		if err != nil {
+			utils.Log.Errorln(err)
+			return failResult(err)
+		}
+		response, err = dnsQuery(tcpDialer, "tcp", dstAddress, packed, queryId, deadline)
+		if err != nil {
+			utils.Log.Traceln(err)
+			return dnsFailResult(err, "udp应答被截断，改用tcp查询失败：")
+		}
+	}
+	rtt := time.Since(startTime)
+	dnsResult := &DnsResult{
+		Rcode:   dnsRcodeName(response.RCode),
+		Answers: dnsAnswers(response.Answers),
+	}
+	if response.RCode != dnsmessage.RCodeSuccess {
+		return PingResult{Reason: FailReasonDnsRcode, Dns: dnsResult}
+	}
+	for _, value := range expect {
+		if !dnsAnswerContains(dnsResult.Answers, value) {
+			return PingResult{Reason: FailReasonDnsMismatch, Dns: dnsResult}
+		}
+	}
+	return PingResult{Success: true, Rtt: rtt, Dns: dnsResult}
+}
+
+// dnsDialer 生成指定了超时时间和源IP的拨号器，srcIp为空时不指定源IP
+func dnsDialer(srcIp string, proto string, timeout time.Duration) (net.Dialer, error) {
+	d := net.Dialer{Timeout: timeout}
+	if srcIp == "" {
+		return d, nil
+	}
+	srcAddress := fmt.Sprintf("%s:0", srcIp)
+	if strings.Contains(srcIp, ":") {
+		srcAddress = fmt.Sprintf("[%s]:0", srcIp)
+	}
+	var err error
+	if proto == "tcp" {
+		d.LocalAddr, err = net.ResolveTCPAddr("tcp", srcAddress)
+	} else {
+		d.LocalAddr, err = net.ResolveUDPAddr("udp", srcAddress)
+	}
+	return d, err
+}
+
+// dnsFailResult 查询失败的结果，应答格式错误等没有归类的错误带上错误信息作为失败详情，prefix为详情的前缀
+func dnsFailResult(err error, prefix string) PingResult {
+	r := failResult(err)
+	if r.Reason == FailReasonOther || prefix != "" {
+		r.Detail = prefix + err.Error()
+	}
+	return r
+}
+
+// dnsQuery 发送查询并读取ID一致的应答，udp收到ID不一致或者头都解析不了的应答时丢弃，继续等到超时；
+// udp应答被截断时只解析头，由调用方改用tcp重新查询
+func dnsQuery(d net.Dialer, proto string, dstAddress string, packed []byte, queryId uint16, deadline time.Time) (dnsmessage.Message, error) {
+	var response dnsmessage.Message
+	conn, err := d.Dial(proto, dstAddress)
+	if err != nil {
+		return response, err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(deadline); err != nil {
+		return response, err
+	}
+	if proto == "tcp" {
+		reply, err := dnsExchangeTcp(conn, packed)
+		if err != nil {
+			return response, err
+		}
+		if err = response.Unpack(reply); err != nil {
+			return response, fmt.Errorf("dns应答格式错误：%v", err)
+		}
+		if response.ID != queryId {
+			return response, fmt.Errorf("dns应答的ID为%d，查询的ID为%d", response.ID, queryId)
+		}
+		return response, nil
+	}
+	if _, err = conn.Write(packed); err != nil {
+		return response, err
+	}
+	reply := make([]byte, 65535)
+	for {
+		n, err := conn.Read(reply)
+		if err != nil {
+			return response, err
+		}
+		var parser dnsmessage.Parser
+		header, err := parser.Start(reply[:n])
+		if err != nil || header.ID != queryId {
+			// 可能是之前超时的查询迟到的应答，或者伪造的应答
+			utils.Log.Traceln("丢弃不是本次查询的dns应答", dstAddress, header.ID, queryId, err)
+			continue
+		}
+		// 被截断的应答里的记录可能不完整，只需要头
+		if header.Truncated {
+			return dnsmessage.Message{Header: header}, nil
+		}
+		if err = response.Unpack(reply[:n]); err != nil {
+			return response, fmt.Errorf("dns应答格式错误：%v", err)
+		}
+		return response, nil
+	}
+}
+
+// dnsExchangeTcp 通过tcp发送查询并读取应答，需要加上2字节的长度
+func dnsExchangeTcp(conn net.Conn, packed []byte) ([]byte, error) {
+	request := make([]byte, 2, 2+len(packed))
+	binary.BigEndian.PutUint16(request, uint16(len(packed)))
+	if _, err := conn.Write(append(request, packed...)); err != nil {
+		return nil, err
+	}
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	if len(reply) == 0 {
+		return nil, errors.New("dns应答为空")
+	}
+	return reply, nil
+}
+
+// dnsAnswers 把应答记录转换为字符串，排序后返回
+func dnsAnswers(resources []dnsmessage.Resource) []string {
+	answers := []string{}
+	for _, resource := range resources {
+		switch body := resource.Body.(type) {
+		case *dnsmessage.AResource:
+			answers = append(answers, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			answers = append(answers, net.IP(body.AAAA[:]).String())
+		case *dnsmessage.CNAMEResource:
+			answers = append(answers, body.CNAME.String())
+		case *dnsmessage.MXResource:
+			answers = append(answers, fmt.Sprintf("%d %s", body.Pref, body.MX.String()))
+		case *dnsmessage.TXTResource:
+			answers = append(answers, strings.Join(body.TXT, ""))
+		case *dnsmessage.SRVResource:
+			answers = append(answers, fmt.Sprintf("%d %d %d %s", body.Priority, body.Weight, body.Port, body.Target.String()))
+		}
+	}
+	sort.Strings(answers)
+	return answers
+}
+
+// dnsAnswerContains 判断应答记录里是否有期望的值，域名末尾的点可有可无
+func dnsAnswerContains(answers []string, value string) bool {
+	for _, answer := range answers {
+		if strings.TrimSuffix(answer, ".") == strings.TrimSuffix(value, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// dnsRcodeName 应答码的名称，同dig的展示
+func dnsRcodeName(rcode dnsmessage.RCode) string {
+	switch rcode {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
@@ -0,0 +1,118 @@
+package ping
+
+import (
+	"encoding/binary"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDnsUdpReply 测试的dns服务的一个udp应答，idDelta为应答ID和查询ID的差，truncated为是否设置TC位
+type testDnsUdpReply struct {
+	idDelta   uint16
+	truncated bool
+}
+
+// testDnsReply 根据查询生成应答，idDelta为应答ID和查询ID的差，truncated为是否设置TC位
+func testDnsReply(t *testing.T, query []byte, idDelta uint16, truncated bool) []byte {
+	var request dnsmessage.Message
+	if err := request.Unpack(query); err != nil {
+		t.Error(err)
+		return nil
+	}
+	reply := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: request.ID + idDelta, Response: true, Truncated: truncated},
+		Questions: request.Questions,
+	}
+	if !truncated {
+		reply.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: request.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+		}}
+	}
+	packed, err := reply.Pack()
+	if err != nil {
+		t.Error(err)
+	}
+	return packed
+}
+
+// testDnsServer 在同一个端口上启动udp和tcp的dns服务，udp依次回复udpReplies，idDelta为0的为正确的应答；
+// tcp为false时不监听tcp，返回端口
+func testDnsServer(t *testing.T, udpReplies []testDnsUdpReply, tcp bool) int {
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { udpConn.Close() })
+	port := udpConn.LocalAddr().(*net.UDPAddr).Port
+	go func() {
+		query := make([]byte, 512)
+		n, peer, err := udpConn.ReadFrom(query)
+		if err != nil {
+			return
+		}
+		for _, reply := range udpReplies {
+			_, _ = udpConn.WriteTo(testDnsReply(t, query[:n], reply.idDelta, reply.truncated), peer)
+		}
+	}()
+	if !tcp {
+		return port
+	}
+	listener, err := net.Listen("tcp", udpConn.LocalAddr().String())
+	if err != nil {
+		t.Skip("tcp端口被占用：", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		length := make([]byte, 2)
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(length))
+		if _, err = io.ReadFull(conn, query); err != nil {
+			return
+		}
+		reply := testDnsReply(t, query, 0, false)
+		binary.BigEndian.PutUint16(length, uint16(len(reply)))
+		_, _ = conn.Write(append(length, reply...))
+	}()
+	return port
+}
+
+func TestDnsPingUdpReply(t *testing.T) {
+	tests := []struct {
+		name       string
+		udpReplies []testDnsUdpReply
+		tcp        bool
+		wantOk     bool
+		wantReason string
+		wantDetail string
+	}{
+		{"正确的应答", []testDnsUdpReply{{0, false}}, false, true, "", ""},
+		{"先丢弃ID不一致的应答", []testDnsUdpReply{{1, false}, {0, false}}, false, true, "", ""},
+		{"只有ID不一致的应答等到超时", []testDnsUdpReply{{1, false}}, false, false, FailReasonTimeout, ""},
+		{"被截断改用tcp", []testDnsUdpReply{{0, true}}, true, true, "", ""},
+		{"被截断tcp失败", []testDnsUdpReply{{0, true}}, false, false, FailReasonRefused, "udp应答被截断，改用tcp查询失败："},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := testDnsServer(t, tt.udpReplies, tt.tcp)
+			got := DnsPing("127.0.0.1", port, 1, "", "www.example.com", "A", "udp", []string{"192.0.2.1"})
+			if got.Success != tt.wantOk || got.Reason != tt.wantReason || !strings.HasPrefix(got.Detail, tt.wantDetail) {
+				t.Errorf("DnsPing() = %+v, want success %v, reason %q, detail %q", got, tt.wantOk, tt.wantReason, tt.wantDetail)
+			}
+			if tt.wantOk && got.Rtt > time.Second {
+				t.Errorf("DnsPing() rtt = %v", got.Rtt)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package ping
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/levigross/grequests"
+	"go_ping/utils"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// HttpOption http打流的选项，可以在命令行整体指定，也可以在文件的每一行单独指定
+type HttpOption struct {
+	Method         string              // 请求方法，为空时为GET
+	RequestHeaders []HttpRequestHeader // 请求需要带上的头
+	RequestBody    []byte              // 请求体，从文件读取
+	Host           string              // 请求的Host头，为空时使用url里的域名
+	Sni            string              // TLS握手时使用的服务器名称，为空时使用Host，都为空时使用url里的域名
+	Redirect       string              // 跳转策略，取值见HttpRedirect开头的变量，为空时跟随跳转
+	RedirectMax    int                 // 跟随跳转时最多跳转的次数，0表示使用默认值
+	StatusList     []HttpStatusRange   // 允许的状态码，为空时任何状态码都算成功
+	Body           string              // 响应体需要包含的字符串
+	BodyRegex      *regexp.Regexp      // 响应体需要匹配的正则
+	Headers        []HttpHeaderCheck   // 响应需要包含的头
+	MaxBodySize    int64               // 响应体的最大字节数，0表示不限制
+}
+
+// 跳转策略
+var (
+	HttpRedirectFollow     = "follow" // 跟随跳转，超过最多跳转次数算失败
+	HttpRedirectNone       = "none"   // 不跟随跳转，以跳转的响应作为最终响应进行校验
+	HttpRedirectFail       = "fail"   // 收到跳转的响应算失败
+	DefaultHttpRedirectMax = 10       // 默认最多跳转的次数，同net/http
+)
+
+// errHttpRedirect 跳转超过最多跳转次数
+var errHttpRedirect = errors.New("跳转次数太多")
+
+// HttpRequestHeader 请求头
+type HttpRequestHeader struct {
+	Name  string
+	Value string
+}
+
+// HttpStatusRange 状态码范围，包含两端
+type HttpStatusRange struct {
+	Min int
+	Max int
+}
+
+// HttpHeaderCheck 响应头校验，Value为空时只校验头存在
+type HttpHeaderCheck struct {
+	Name  string
+	Value string
+}
+
+// HttpOptionKeyList 文件每一行可以指定的选项，格式为key=value，对应的命令行参数为http.key，sni对应的命令行参数为tls.sni
+var HttpOptionKeyList = []string{"method", "request.header", "request.body.file", "host", "sni", "redirect", "redirect.max", "status", "body", "body.regex", "header", "body.max"}
+
+// Set 设置一个选项，key见HttpOptionKeyList
+func (o *HttpOption) Set(key string, value string) error {
+	switch key {
+	case "method":
+		method := strings.ToUpper(value)
+		if !utils.ContainsString(utils.HttpMethodList, method) {
+			return fmt.Errorf("不支持的请求方法：%s，支持的请求方法：%s", value, strings.Join(utils.HttpMethodList, ","))
+		}
+		o.Method = method
+	case "request.header":
+		// 多个头用逗号分隔，每个头的格式为Name:Value，Value里的$VAR或${VAR}会替换为环境变量的值，适合传递密钥
+		for _, header := range strings.Split(value, ",") {
+			name, headerValue, found := strings.Cut(header, ":")
+			name = strings.TrimSpace(name)
+			if !found || name == "" {
+				return fmt.Errorf("请求头格式错误：%s", header)
+			}
+			headerValue, err := expandEnv(strings.TrimSpace(headerValue))
+			if err != nil {
+				return err
+			}
+			// Host头需要单独设置
+			if strings.EqualFold(name, "Host") {
+				o.Host = headerValue
+				continue
+			}
+			o.RequestHeaders = append(o.RequestHeaders, HttpRequestHeader{Name: name, Value: headerValue})
+		}
+	case "request.body.file":
+		requestBody, err := os.ReadFile(value)
+		if err != nil {
+			return err
+		}
+		o.RequestBody = requestBody
+	case "host":
+		o.Host = value
+	case "sni":
+		o.Sni = value
+	case "redirect":
+		if !utils.ContainsString(utils.HttpRedirectList, value) {
+			return fmt.Errorf("不支持的跳转策略：%s，支持的跳转策略：%s", value, strings.Join(utils.HttpRedirectList, ","))
+		}
+		o.Redirect = value
+	case "redirect.max":
+		redirectMax, err := strconv.Atoi(value)
+		if err != nil || redirectMax < 1 || redirectMax > utils.HttpRedirectMaxLimit {
+			return fmt.Errorf("最多跳转次数格式错误：%s，取值[1~%d]", value, utils.HttpRedirectMaxLimit)
+		}
+		o.RedirectMax = redirectMax
+	case "status":
+		statusList, err := parseHttpStatus(value)
+		if err != nil {
+			return err
+		}
+		o.StatusList = statusList
+	case "body":
+		o.Body = value
+	case "body.regex":
+		bodyRegex, err := regexp.Compile(value)
+		if err != nil {
+			return err
+		}
+		o.BodyRegex = bodyRegex
+	case "header":
+		// 多个头用逗号分隔，每个头的格式为Name或者Name:Value
+		for _, header := range strings.Split(value, ",") {
+			name, headerValue, _ := strings.Cut(header, ":")
+			name = strings.TrimSpace(name)
+			if name == "" {
+				return fmt.Errorf("响应头格式错误：%s", header)
+			}
+			o.Headers = append(o.Headers, HttpHeaderCheck{Name: name, Value: strings.TrimSpace(headerValue)})
+		}
+	case "body.max":
+		maxBodySize, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || maxBodySize < 0 {
+			return fmt.Errorf("响应体最大字节数格式错误：%s", value)
+		}
+		o.MaxBodySize = maxBodySize
+	default:
+		return fmt.Errorf("不支持的选项：%s", key)
+	}
+	return nil
+}
+
+// Clone 复制一份选项，文件每一行在命令行选项的基础上修改
+func (o *HttpOption) Clone() *HttpOption {
+	newOption := *o
+	newOption.RequestHeaders = append([]HttpRequestHeader{}, o.RequestHeaders...)
+	newOption.StatusList = append([]HttpStatusRange{}, o.StatusList...)
+	newOption.Headers = append([]HttpHeaderCheck{}, o.Headers...)
+	return &newOption
+}
+
+// requestOptions 把请求头、请求体、Host头设置到grequests的RequestOptions里，返回请求方法
+func (o *HttpOption) requestOptions(ro *grequests.RequestOptions) string {
+	if len(o.RequestHeaders) > 0 {
+		ro.Headers = make(map[string]string, len(o.RequestHeaders))
+		for _, header := range o.RequestHeaders {
+			ro.Headers[header.Name] = header.Value
+		}
+	}
+	if o.RequestBody != nil {
+		ro.RequestBody = bytes.NewReader(o.RequestBody)
+	}
+	ro.Host = o.Host
+	if o.Method == "" {
+		return http.MethodGet
+	}
+	return o.Method
+}
+
+// tlsConfig 指定了SNI或者Host时，在命令行的TLS配置基础上修改握手时使用的服务器名称
+func (o *HttpOption) tlsConfig(baseConfig *tls.Config) *tls.Config {
+	serverName := o.Sni
+	if serverName == "" && o.Host != "" {
+		serverName = o.Host
+		if host, _, err := net.SplitHostPort(o.Host); err == nil {
+			serverName = host
+		}
+	}
+	if serverName == "" || (baseConfig != nil && baseConfig.ServerName == serverName) {
+		return baseConfig
+	}
+	if baseConfig == nil {
+		return &tls.Config{ServerName: serverName}
+	}
+	newConfig := baseConfig.Clone()
+	newConfig.ServerName = serverName
+	return newConfig
+}
+
+// checkRedirect 跳转前根据跳转策略判断是否继续跳转，viaNumber为已经发出的请求数，option为nil时跟随跳转
+func (o *HttpOption) checkRedirect(viaNumber int) error {
+	if o != nil && (o.Redirect == HttpRedirectNone || o.Redirect == HttpRedirectFail) {
+		// 不跟随跳转，以跳转的响应作为最终响应
+		return http.ErrUseLastResponse
+	}
+	if viaNumber > o.redirectMax() {
+		return errHttpRedirect
+	}
+	return nil
+}
+
+// redirectMax 最多跳转的次数，option为nil或者没有指定时使用默认值
+func (o *HttpOption) redirectMax() int {
+	if o == nil || o.RedirectMax == 0 {
+		return DefaultHttpRedirectMax
+	}
+	return o.RedirectMax
+}
+
+// expandEnv 替换字符串里的环境变量，环境变量没有设置时报错，防止密钥漏传
+func expandEnv(value string) (string, error) {
+	var missingList []string
+	expanded := os.Expand(value, func(name string) string {
+		envValue, exists := os.LookupEnv(name)
+		if !exists {
+			missingList = append(missingList, name)
+		}
+		return envValue
+	})
+	if len(missingList) > 0 {
+		return "", fmt.Errorf("环境变量没有设置：%s", strings.Join(missingList, ","))
+	}
+	return expanded, nil
+}
+
+// needBody 是否需要读取响应体进行校验
+func (o *HttpOption) needBody() bool {
+	return o.Body != "" || o.BodyRegex != nil || o.MaxBodySize > 0
+}
+
+// checkStatus 校验状态码，不符合时返回失败详情
+func (o *HttpOption) checkStatus(statusCode int) string {
+	if len(o.StatusList) == 0 {
+		return ""
+	}
+	for _, statusRange := range o.StatusList {
+		if statusCode >= statusRange.Min && statusCode <= statusRange.Max {
+			return ""
+		}
+	}
+	return fmt.Sprintf("状态码%d不在允许的范围内", statusCode)
+}
+
+// checkHeader 校验响应头，不符合时返回失败详情
+func (o *HttpOption) checkHeader(header http.Header) string {
+	for _, headerCheck := range o.Headers {
+		values, exists := header[http.CanonicalHeaderKey(headerCheck.Name)]
+		if !exists {
+			return fmt.Sprintf("缺少响应头%s", headerCheck.Name)
+		}
+		if headerCheck.Value == "" {
+			continue
+		}
+		matched := false
+		for _, value := range values {
+			if value == headerCheck.Value {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Sprintf("响应头%s的值不是%s", headerCheck.Name, headerCheck.Value)
+		}
+	}
+	return ""
+}
+
+// checkBody 校验响应体，不符合时返回失败详情
+func (o *HttpOption) checkBody(body []byte) string {
+	if o.Body != "" && !strings.Contains(string(body), o.Body) {
+		return fmt.Sprintf("响应体不包含%s", o.Body)
+	}
+	if o.BodyRegex != nil && !o.BodyRegex.Match(body) {
+		return fmt.Sprintf("响应体不匹配正则%s", o.BodyRegex.String())
+	}
+	return ""
+}
+
+// parseHttpStatus 解析状态码，多个用逗号分隔，支持200、200-299、2xx三种格式
+func parseHttpStatus(value string) ([]HttpStatusRange, error) {
+	var statusList []HttpStatusRange
+	for _, status := range strings.Split(value, ",") {
+		status = strings.TrimSpace(status)
+		var statusRange HttpStatusRange
+		var err, err1 error
+		if strings.HasSuffix(strings.ToLower(status), "xx") && len(status) == 3 {
+			var prefix int
+			prefix, err = strconv.Atoi(status[:1])
+			statusRange = HttpStatusRange{Min: prefix * 100, Max: prefix*100 + 99}
+		} else if minStatus, maxStatus, found := strings.Cut(status, "-"); found {
+			statusRange.Min, err = strconv.Atoi(minStatus)
+			statusRange.Max, err1 = strconv.Atoi(maxStatus)
+		} else {
+			statusRange.Min, err = strconv.Atoi(status)
+			statusRange.Max = statusRange.Min
+		}
+		if err != nil || err1 != nil || statusRange.Min < 100 || statusRange.Max > 599 || statusRange.Min > statusRange.Max {
+			return nil, errors.New("状态码格式错误：" + status)
+		}
+		statusList = append(statusList, statusRange)
+	}
+	return statusList, nil
+}
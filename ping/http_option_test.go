@@ -0,0 +1,129 @@
+package ping
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestParseHttpStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []HttpStatusRange
+		wantErr bool
+	}{
+		{"单个", "200", []HttpStatusRange{{200, 200}}, false},
+		{"范围", "200-299", []HttpStatusRange{{200, 299}}, false},
+		{"2xx", "2xx", []HttpStatusRange{{200, 299}}, false},
+		{"大写XX", "3XX", []HttpStatusRange{{300, 399}}, false},
+		{"多个带空格", "200, 301-302 ,4xx", []HttpStatusRange{{200, 200}, {301, 302}, {400, 499}}, false},
+		{"小于100", "99", nil, true},
+		{"大于599", "600", nil, true},
+		{"6xx", "6xx", nil, true},
+		{"范围反了", "299-200", nil, true},
+		{"范围不完整", "200-", nil, true},
+		{"不是数字", "ok", nil, true},
+		{"x不在前面", "x2x", nil, true},
+		{"空", "", nil, true},
+		{"多个里有一个错", "200,abc", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHttpStatus(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHttpStatus(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHttpStatus(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHttpOptionSetAssertion(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		want    HttpOption
+		wantErr bool
+	}{
+		{"状态码", "status", "2xx,301", HttpOption{StatusList: []HttpStatusRange{{200, 299}, {301, 301}}}, false},
+		{"状态码格式错误", "status", "abc", HttpOption{}, true},
+		{"响应头", "header", "Content-Type: text/html , X-Ok", HttpOption{Headers: []HttpHeaderCheck{{"Content-Type", "text/html"}, {"X-Ok", ""}}}, false},
+		{"响应头名字为空", "header", ":text/html", HttpOption{}, true},
+		{"响应体", "body", "ok", HttpOption{Body: "ok"}, false},
+		{"响应体正则", "body.regex", `"status":\s*"up"`, HttpOption{BodyRegex: regexp.MustCompile(`"status":\s*"up"`)}, false},
+		{"响应体正则错误", "body.regex", "(", HttpOption{}, true},
+		{"响应体最大字节数", "body.max", "1024", HttpOption{MaxBodySize: 1024}, false},
+		{"响应体最大字节数为负数", "body.max", "-1", HttpOption{}, true},
+		{"不支持的选项", "status.code", "200", HttpOption{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got HttpOption
+			err := got.Set(tt.key, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set(%q, %q) error = %v, wantErr %v", tt.key, tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Set(%q, %q) = %+v, want %+v", tt.key, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHttpOptionCheck(t *testing.T) {
+	option := &HttpOption{
+		StatusList: []HttpStatusRange{{200, 299}, {304, 304}},
+		Headers:    []HttpHeaderCheck{{"content-type", "application/json"}, {"X-Request-Id", ""}},
+		Body:       "up",
+		BodyRegex:  regexp.MustCompile(`"version":\s*\d+`),
+	}
+	header := http.Header{}
+	header.Add("Content-Type", "text/plain")
+	header.Add("Content-Type", "application/json")
+	header.Set("X-Request-Id", "1")
+	tests := []struct {
+		name       string
+		statusCode int
+		header     http.Header
+		body       string
+		want       []string // 状态码、响应头、响应体的失败详情
+	}{
+		{"全部通过", 204, header, `{"status":"up","version": 3}`, []string{"", "", ""}},
+		{"304", 304, header, `up "version":1`, []string{"", "", ""}},
+		{"状态码不对", 500, header, `up "version":1`, []string{"状态码500不在允许的范围内", "", ""}},
+		{"缺少响应头", 200, http.Header{"Content-Type": {"application/json"}}, `up "version":1`, []string{"", "缺少响应头X-Request-Id", ""}},
+		{"响应头的值不对", 200, http.Header{"Content-Type": {"text/html"}, "X-Request-Id": {"1"}}, `up "version":1`, []string{"", "响应头content-type的值不是application/json", ""}},
+		{"响应体不包含", 200, header, `down "version":1`, []string{"", "", "响应体不包含up"}},
+		{"响应体不匹配正则", 200, header, `up`, []string{"", "", `响应体不匹配正则"version":\s*\d+`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{option.checkStatus(tt.statusCode), option.checkHeader(tt.header), option.checkBody([]byte(tt.body))}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("check = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHttpOptionCheckEmpty(t *testing.T) {
+	// 没有指定校验时任何响应都通过
+	option := &HttpOption{}
+	if got := option.checkStatus(500); got != "" {
+		t.Errorf("checkStatus() = %q, want empty", got)
+	}
+	if got := option.checkHeader(http.Header{}); got != "" {
+		t.Errorf("checkHeader() = %q, want empty", got)
+	}
+	if got := option.checkBody(nil); got != "" {
+		t.Errorf("checkBody() = %q, want empty", got)
+	}
+	if option.needBody() {
+		t.Errorf("needBody() = true, want false")
+	}
+}
@@ -1,63 +1,181 @@
 package ping
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"github.com/levigross/grequests"
 	"go_ping/utils"
+	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"time"
 )
 
 // HttpPing http ping原子函数，每个goroutines执行的
-func HttpPing(domain string, timeout int, srcIp string) bool {
-	// 创建grequests的RequestOptions
+// url支持http和https，tlsConfig为nil时使用系统默认的TLS配置，option为nil时发送GET请求，任何响应都算成功
+// transportCache为nil时每次探测新建连接，测量完整的建连耗时；不为nil时复用协程里的长连接，测量稳定状态下的应用时延
+func HttpPing(url string, timeout int, srcIp string, tlsConfig *tls.Config, option *HttpOption, transportCache *HttpTransportCache) PingResult {
 	if timeout < 1 {
 		timeout = 1
 	}
 	requestTimeout := time.Duration(timeout) * time.Second
+	// 文件的行或者命令行指定了SNI、Host时，修改握手时使用的服务器名称
+	if option != nil {
+		tlsConfig = option.tlsConfig(tlsConfig)
+	}
+	var transport *http.Transport
+	if transportCache != nil {
+		transport = transportCache.get(srcIp, tlsConfig)
+	} else {
+		transport = newHttpTransport(srcIp, tlsConfig, false)
+		// 探测完关闭连接，避免连接泄露
+		defer transport.CloseIdleConnections()
+	}
+	// 记录跳转的url
+	var redirects []string
+	// 创建自定义的HTTP客户端，超时时间包含建连、请求、跳转和读完响应体
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   requestTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if err := option.checkRedirect(len(via)); err != nil {
+				return err
+			}
+			redirects = append(redirects, req.URL.String())
+			return nil
+		},
+	}
+	// 创建grequests的RequestOptions，配置自定义客户端
 	ro := &grequests.RequestOptions{
+		HTTPClient:     client,
 		RequestTimeout: requestTimeout,
 	}
-	// 指定源IP
-	if srcIp != "" {
-		localAddr := net.ParseIP(srcIp)
-		// 自定义DialContext函数，允许我们指定源IP地址
-		dialer := &net.Dialer{
-			LocalAddr: &net.TCPAddr{
-				IP: localAddr,
-			},
-			//Timeout:   30 * time.Second,
-			//KeepAlive: 30 * time.Second,
-		}
-		// 创建自定义的Transport，使用上面的Dialer
-		transport := &http.Transport{
-			Proxy:       http.ProxyFromEnvironment,
-			DialContext: dialer.DialContext,
-			//TLSHandshakeTimeout: 10 * time.Second,
-		}
-		// 创建自定义的HTTP客户端
-		client := &http.Client{
-			Transport: transport,
-		}
-		// 创建grequests的RequestOptions，配置自定义客户端
-		ro = &grequests.RequestOptions{
-			HTTPClient:     client,
-			RequestTimeout: requestTimeout,
-		}
+	// 请求方法、请求头、请求体、Host头
+	method := http.MethodGet
+	if option != nil {
+		method = option.requestOptions(ro)
 	}
-	result := true
+	// 记录各阶段耗时
+	tracer := &httpTracer{}
+	ro.Context = httptrace.WithClientTrace(context.Background(), tracer.clientTrace())
+	result := PingResult{Success: true}
+	startTime := time.Now()
 	// 使用grequests发出请求，同时使用自定义源IP
-	resp, err := grequests.Get(domain, ro)
+	resp, err := grequests.Req(method, url, ro)
 	if err != nil {
 		utils.Log.Traceln(err)
-		result = false
+		result = failResult(err)
+		if result.Reason == FailReasonHttpRedirect {
+			result.Detail = fmt.Sprintf("跳转超过%d次", option.redirectMax())
+		}
+	} else if option != nil {
+		// 校验响应
+		result = checkHttpResponse(resp, option)
 	}
-	if resp != nil {
-		err1 := resp.Close()
-		if err1 != nil {
+	if resp != nil && resp.RawResponse != nil {
+		// Close会读完响应体，所以时延包含整个请求时间；响应体超过最大字节数时直接关闭，不再读取
+		var err1 error
+		if result.Reason == FailReasonHttpBodySize {
+			err1 = resp.RawResponse.Body.Close()
+		} else {
+			err1 = resp.Close()
+		}
+		if err1 != nil && result.Success {
 			utils.Log.Traceln(err1)
-			result = false
+			result = failResult(err1)
 		}
 	}
+	endTime := time.Now()
+	if result.Success {
+		result.Rtt = endTime.Sub(startTime)
+	}
+	result.Http = tracer.timing(endTime)
+	result.Redirects = redirects
 	return result
 }
+
+// checkHttpResponse 根据选项校验响应，依次校验跳转、状态码、响应头、响应体
+func checkHttpResponse(resp *grequests.Response, option *HttpOption) PingResult {
+	// 跳转策略为fail时，收到跳转的响应直接算失败
+	if option.Redirect == HttpRedirectFail && resp.StatusCode >= 300 && resp.StatusCode < 400 && resp.Header.Get("Location") != "" {
+		return PingResult{Reason: FailReasonHttpRedirect, Detail: fmt.Sprintf("状态码%d跳转到%s", resp.StatusCode, resp.Header.Get("Location"))}
+	}
+	if detail := option.checkStatus(resp.StatusCode); detail != "" {
+		return PingResult{Reason: FailReasonHttpStatus, Detail: detail}
+	}
+	if detail := option.checkHeader(resp.Header); detail != "" {
+		return PingResult{Reason: FailReasonHttpHeader, Detail: detail}
+	}
+	if !option.needBody() {
+		return PingResult{Success: true}
+	}
+	var reader io.Reader = resp
+	if option.MaxBodySize > 0 {
+		// 多读一个字节，判断是否超过最大字节数
+		reader = io.LimitReader(resp, option.MaxBodySize+1)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		utils.Log.Traceln(err)
+		return failResult(err)
+	}
+	if option.MaxBodySize > 0 && int64(len(body)) > option.MaxBodySize {
+		return PingResult{Reason: FailReasonHttpBodySize, Detail: fmt.Sprintf("响应体超过%d字节", option.MaxBodySize)}
+	}
+	if detail := option.checkBody(body); detail != "" {
+		return PingResult{Reason: FailReasonHttpBody, Detail: detail}
+	}
+	return PingResult{Success: true}
+}
+
+// HttpTransportCache 协程里复用的Transport，保持长连接，持续打流时跨轮次复用，不能在多个协程之间同时使用
+type HttpTransportCache struct {
+	transportMap map[string]*http.Transport
+}
+
+// NewHttpTransportCache 调度时每个协程初始化一个
+func NewHttpTransportCache() *HttpTransportCache {
+	return &HttpTransportCache{transportMap: make(map[string]*http.Transport)}
+}
+
+// get 源IP和TLS握手的服务器名称相同的探测复用一个Transport
+func (c *HttpTransportCache) get(srcIp string, tlsConfig *tls.Config) *http.Transport {
+	key := srcIp
+	if tlsConfig != nil {
+		key += "|" + tlsConfig.ServerName
+	}
+	transport, exists := c.transportMap[key]
+	if !exists {
+		transport = newHttpTransport(srcIp, tlsConfig, true)
+		c.transportMap[key] = transport
+	}
+	return transport
+}
+
+// Close 调度结束时关闭所有长连接
+func (c *HttpTransportCache) Close() {
+	for _, transport := range c.transportMap {
+		transport.CloseIdleConnections()
+	}
+}
+
+// newHttpTransport 使用自定义的Dialer和TLS配置创建Transport；grequests默认的Transport使用Dial建连，记录不到建连耗时
+// keepAlive为false时每次请求后关闭连接
+func newHttpTransport(srcIp string, tlsConfig *tls.Config, keepAlive bool) *http.Transport {
+	dialer := &net.Dialer{}
+	if srcIp != "" {
+		localAddr := net.ParseIP(srcIp)
+		// 自定义DialContext函数，允许我们指定源IP地址
+		dialer.LocalAddr = &net.TCPAddr{
+			IP: localAddr,
+		}
+	}
+	return &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		DialContext:       dialer.DialContext,
+		TLSClientConfig:   tlsConfig,
+		DisableKeepAlives: !keepAlive,
+	}
+}
@@ -0,0 +1,106 @@
+package ping
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// HttpTiming http打流每个阶段的耗时，各阶段相加约等于整个请求时间
+// 复用连接时没有dns、建连、TLS握手阶段，对应的耗时为0；有跳转时只统计最后一个请求
+type HttpTiming struct {
+	Dns      time.Duration // 域名解析
+	Connect  time.Duration // tcp建连
+	Tls      time.Duration // TLS握手，http为0
+	Ttfb     time.Duration // 拿到连接到收到响应第一个字节，包含发送请求和服务端处理时间
+	Transfer time.Duration // 收到响应第一个字节到读完响应体
+}
+
+// httpTracer 记录一次请求各阶段的时间点，回调可能在其他goroutine里执行，需要加锁
+type httpTracer struct {
+	mutex        sync.Mutex
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	firstByte    time.Time
+}
+
+// clientTrace 生成httptrace的回调
+func (t *httpTracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			// 每个请求开始获取连接，跳转时清除上一个请求的时间点，只统计最后一个请求
+			t.mutex.Lock()
+			t.dnsStart, t.dnsDone = time.Time{}, time.Time{}
+			t.connectStart, t.connectDone = time.Time{}, time.Time{}
+			t.tlsStart, t.tlsDone = time.Time{}, time.Time{}
+			t.gotConn, t.firstByte = time.Time{}, time.Time{}
+			t.mutex.Unlock()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mark(&t.dnsStart)
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mark(&t.dnsDone)
+		},
+		ConnectStart: func(string, string) {
+			// 多个地址时会尝试多次建连，记录第一次开始的时间
+			t.mutex.Lock()
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+			t.mutex.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			t.mark(&t.connectDone)
+		},
+		TLSHandshakeStart: func() {
+			t.mark(&t.tlsStart)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mark(&t.tlsDone)
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			t.mark(&t.gotConn)
+		},
+		GotFirstResponseByte: func() {
+			t.mark(&t.firstByte)
+		},
+	}
+}
+
+// mark 记录时间点
+func (t *httpTracer) mark(point *time.Time) {
+	t.mutex.Lock()
+	*point = time.Now()
+	t.mutex.Unlock()
+}
+
+// timing 请求结束后计算每个阶段的耗时，没有收到响应时返回nil
+func (t *httpTracer) timing(endTime time.Time) *HttpTiming {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.firstByte.IsZero() {
+		return nil
+	}
+	return &HttpTiming{
+		Dns:      since(t.dnsStart, t.dnsDone),
+		Connect:  since(t.connectStart, t.connectDone),
+		Tls:      since(t.tlsStart, t.tlsDone),
+		Ttfb:     since(t.gotConn, t.firstByte),
+		Transfer: since(t.firstByte, endTime),
+	}
+}
+
+// since 两个时间点之间的耗时，有一个时间点没有记录时为0
+func since(start time.Time, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
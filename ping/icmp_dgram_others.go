@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package ping
+
+import (
+	"errors"
+	"net"
+)
+
+// listenIcmpDgram 其他系统不支持非特权的icmp套接字
+func listenIcmpDgram(srcIp string, v6 bool) (net.PacketConn, error) {
+	return nil, errors.New("当前系统不支持非特权的icmp套接字")
+}
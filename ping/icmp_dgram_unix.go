@@ -0,0 +1,65 @@
+//go:build linux || darwin
+
+package ping
+
+import (
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// darwinIpStripHdr macOS的IP_STRIPHDR，收包时去掉ipv4头，syscall包里没有定义
+const darwinIpStripHdr = 0x17
+
+// listenIcmpDgram 创建非特权的icmp套接字（SOCK_DGRAM + IPPROTO_ICMP），不需要root权限
+// Linux需要当前用户组在net.ipv4.ping_group_range里，macOS默认允许
+func listenIcmpDgram(srcIp string, v6 bool) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	var sa syscall.Sockaddr = &syscall.SockaddrInet4{}
+	if v6 {
+		family, proto = syscall.AF_INET6, syscall.IPPROTO_ICMPV6
+		sa = &syscall.SockaddrInet6{}
+	}
+	if srcIp != "" {
+		ip := net.ParseIP(srcIp)
+		if v6 {
+			if ip.To4() != nil {
+				return nil, errors.New("源IP不是ipv6地址")
+			}
+			copy(sa.(*syscall.SockaddrInet6).Addr[:], ip.To16())
+		} else {
+			if ip.To4() == nil {
+				return nil, errors.New("源IP不是ipv4地址")
+			}
+			copy(sa.(*syscall.SockaddrInet4).Addr[:], ip.To4())
+		}
+	}
+	s, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if runtime.GOOS == "darwin" && !v6 {
+		if err = syscall.SetsockoptInt(s, syscall.IPPROTO_IP, darwinIpStripHdr, 1); err != nil {
+			syscall.Close(s)
+			return nil, os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if err = syscall.Bind(s, sa); err != nil {
+		syscall.Close(s)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	f := os.NewFile(uintptr(s), "datagram-oriented icmp")
+	defer f.Close()
+	c, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, ok := c.(*net.UDPConn)
+	if !ok {
+		c.Close()
+		return nil, errors.New("非特权icmp套接字类型不对")
+	}
+	return &icmpDgramConn{UDPConn: udpConn}, nil
+}
@@ -0,0 +1,168 @@
+package ping
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// 引用的原始ip包的协议号
+const (
+	protoIcmp   = 1
+	protoTcp    = 6
+	protoUdp    = 17
+	protoIcmpV6 = 58
+)
+
+// icmp报文的类型
+const (
+	icmpV4TypeEchoReply    = 0
+	icmpV6TypeEchoReply    = 129
+	icmpV4TypeDstUnreach   = 3
+	icmpV4TypeTimeExceeded = 11
+	icmpV4TypeEchoRequest  = 8
+	icmpV6TypeDstUnreach   = 1
+	icmpV6TypePacketTooBig = 2
+	icmpV6TypeTimeExceeded = 3
+	icmpV6TypeEchoRequest  = 128
+)
+
+// IcmpErrorReply 路由器或者目标返回的icmp差错报文，报文里引用了原始echo请求的头
+type IcmpErrorReply struct {
+	Id         int         // 原始echo请求的ID，引用的内容里有发包信息时以发包信息里的ID为准
+	Seq        int         // 原始echo请求的序号
+	Payload    IcmpPayload // 引用的发包信息，路由器只引用了8字节icmp头时没有
+	HasPayload bool        // 是否引用到了发包信息
+	Result     PingResult  // 失败原因，详情里带上返回差错报文的地址
+}
+
+// ParseIcmpError 解析目标不可达、TTL超时和ipv6的包过大这几种差错报文，只认引用了echo请求的
+// b为不包含ip头的icmp报文，router为返回差错报文的地址
+func ParseIcmpError(b []byte, v6 bool, router string) (IcmpErrorReply, bool) {
+	if len(b) < 8 {
+		return IcmpErrorReply{}, false
+	}
+	var result PingResult
+	var ok bool
+	if v6 {
+		result, ok = icmpV6ErrorResult(b)
+	} else {
+		result, ok = icmpV4ErrorResult(b)
+	}
+	if !ok {
+		return IcmpErrorReply{}, false
+	}
+	echo, ok := quotedEcho(b[8:], v6)
+	if !ok {
+		return IcmpErrorReply{}, false
+	}
+	result.Detail = fmt.Sprintf("来自%s：%s", router, result.Detail)
+	reply := IcmpErrorReply{
+		Id:     int(binary.BigEndian.Uint16(echo[4:6])),
+		Seq:    int(binary.BigEndian.Uint16(echo[6:8])),
+		Result: result,
+	}
+	reply.Payload, reply.HasPayload = ParseIcmpPayload(echo[8:])
+	if reply.HasPayload {
+		reply.Id = reply.Payload.Id
+	}
+	return reply, true
+}
+
+// quotedEcho 从差错报文引用的原始ip包里取出echo请求，至少包含8字节icmp头
+func quotedEcho(quoted []byte, v6 bool) ([]byte, bool) {
+	proto, echo, ok := quotedTransport(quoted, v6)
+	if !ok || (v6 && proto != protoIcmpV6) || (!v6 && proto != protoIcmp) {
+		return nil, false
+	}
+	if (v6 && echo[0] != icmpV6TypeEchoRequest) || (!v6 && echo[0] != icmpV4TypeEchoRequest) {
+		return nil, false
+	}
+	return echo, true
+}
+
+// quotedTransport 从差错报文引用的原始ip包里取出协议号和传输层头，传输层头至少8字节
+func quotedTransport(quoted []byte, v6 bool) (int, []byte, bool) {
+	var proto int
+	var transport []byte
+	if v6 {
+		// 只处理没有扩展头的ipv6包
+		if len(quoted) < 40 || quoted[0]>>4 != 6 {
+			return 0, nil, false
+		}
+		proto = int(quoted[6])
+		transport = quoted[40:]
+	} else {
+		if len(quoted) < 20 || quoted[0]>>4 != 4 {
+			return 0, nil, false
+		}
+		headerLen := int(quoted[0]&0x0f) * 4
+		if headerLen < 20 || len(quoted) < headerLen {
+			return 0, nil, false
+		}
+		proto = int(quoted[9])
+		transport = quoted[headerLen:]
+	}
+	if len(transport) < 8 {
+		return 0, nil, false
+	}
+	return proto, transport, true
+}
+
+// icmpV4ErrorResult ipv4差错报文对应的失败原因
+func icmpV4ErrorResult(b []byte) (PingResult, bool) {
+	code := b[1]
+	switch b[0] {
+	case icmpV4TypeDstUnreach:
+		switch code {
+		case 0, 6:
+			return PingResult{Reason: FailReasonUnreachable, Detail: "网络不可达"}, true
+		case 1, 7:
+			return PingResult{Reason: FailReasonUnreachable, Detail: "主机不可达"}, true
+		case 2:
+			return PingResult{Reason: FailReasonUnreachable, Detail: "协议不可达"}, true
+		case 3:
+			return PingResult{Reason: FailReasonUnreachable, Detail: "端口不可达"}, true
+		case 4:
+			// 需要分片但设置了不分片，头里第6、7字节是下一跳的MTU
+			return PingResult{Reason: FailReasonMtu, Detail: fmt.Sprintf("需要分片，下一跳MTU为%d", binary.BigEndian.Uint16(b[6:8]))}, true
+		case 9, 10, 13:
+			return PingResult{Reason: FailReasonProhibited, Detail: "被管理策略禁止"}, true
+		default:
+			return PingResult{Reason: FailReasonUnreachable, Detail: fmt.Sprintf("目标不可达（code %d）", code)}, true
+		}
+	case icmpV4TypeTimeExceeded:
+		if code == 0 {
+			return PingResult{Reason: FailReasonTtlExceeded, Detail: "TTL超时"}, true
+		}
+		return PingResult{Reason: FailReasonOther, Detail: "分片重组超时"}, true
+	}
+	return PingResult{}, false
+}
+
+// icmpV6ErrorResult ipv6差错报文对应的失败原因
+func icmpV6ErrorResult(b []byte) (PingResult, bool) {
+	code := b[1]
+	switch b[0] {
+	case icmpV6TypeDstUnreach:
+		switch code {
+		case 0:
+			return PingResult{Reason: FailReasonUnreachable, Detail: "没有路由"}, true
+		case 1, 5, 6:
+			return PingResult{Reason: FailReasonProhibited, Detail: "被管理策略禁止"}, true
+		case 3:
+			return PingResult{Reason: FailReasonUnreachable, Detail: "地址不可达"}, true
+		case 4:
+			return PingResult{Reason: FailReasonUnreachable, Detail: "端口不可达"}, true
+		default:
+			return PingResult{Reason: FailReasonUnreachable, Detail: fmt.Sprintf("目标不可达（code %d）", code)}, true
+		}
+	case icmpV6TypePacketTooBig:
+		return PingResult{Reason: FailReasonMtu, Detail: fmt.Sprintf("包过大，下一跳MTU为%d", binary.BigEndian.Uint32(b[4:8]))}, true
+	case icmpV6TypeTimeExceeded:
+		if code == 0 {
+			return PingResult{Reason: FailReasonTtlExceeded, Detail: "hop limit超时"}, true
+		}
+		return PingResult{Reason: FailReasonOther, Detail: "分片重组超时"}, true
+	}
+	return PingResult{}, false
+}
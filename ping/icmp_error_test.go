@@ -0,0 +1,185 @@
+package ping
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// testIpv4Header 引用的原始ipv4头，optionWords为选项的32位字数
+func testIpv4Header(proto byte, optionWords int) []byte {
+	header := make([]byte, 20+optionWords*4)
+	header[0] = 4<<4 | byte(5+optionWords)
+	header[8] = 1
+	header[9] = proto
+	return header
+}
+
+// testIpv6Header 引用的原始ipv6头
+func testIpv6Header(nextHeader byte) []byte {
+	header := make([]byte, 40)
+	header[0] = 6 << 4
+	header[6] = nextHeader
+	header[7] = 1
+	return header
+}
+
+// testEchoRequest echo请求，data为发包内容
+func testEchoRequest(v6 bool, id int, seq int, data []byte) []byte {
+	echo := make([]byte, 8, 8+len(data))
+	echo[0] = icmpV4TypeEchoRequest
+	if v6 {
+		echo[0] = icmpV6TypeEchoRequest
+	}
+	binary.BigEndian.PutUint16(echo[4:6], uint16(id))
+	binary.BigEndian.PutUint16(echo[6:8], uint16(seq))
+	return append(echo, data...)
+}
+
+// testIcmpError 差错报文，rest为头里第4到7字节，quoted为引用的原始ip包
+func testIcmpError(messageType byte, code byte, rest uint32, quoted ...[]byte) []byte {
+	b := make([]byte, 8)
+	b[0] = messageType
+	b[1] = code
+	binary.BigEndian.PutUint32(b[4:8], rest)
+	return append(b, bytes.Join(quoted, nil)...)
+}
+
+func TestParseIcmpError(t *testing.T) {
+	sendTime := time.Unix(1700000000, 0)
+	// 发包内容里的ID为0x1234，echo头里的ID被非特权套接字改写为0x9999
+	payload := genIcmpPayload(sendTime, 0x1234, 3, nil)
+	tests := []struct {
+		name            string
+		b               []byte
+		v6              bool
+		wantOk          bool
+		wantId          int
+		wantSeq         int
+		wantHasPayload  bool
+		wantReason      string
+		wantDetail      string
+		wantPayloadTime time.Time
+	}{
+		{
+			name:   "v4 TTL超时引用了发包内容",
+			b:      testIcmpError(icmpV4TypeTimeExceeded, 0, 0, testIpv4Header(protoIcmp, 0), testEchoRequest(false, 0x9999, 5, payload)),
+			wantOk: true, wantId: 0x1234, wantSeq: 5, wantHasPayload: true,
+			wantReason: FailReasonTtlExceeded, wantDetail: "来自192.0.2.1：TTL超时", wantPayloadTime: sendTime,
+		},
+		{
+			name:   "v4需要分片只引用了8字节头",
+			b:      testIcmpError(icmpV4TypeDstUnreach, 4, 1400, testIpv4Header(protoIcmp, 0), testEchoRequest(false, 0x9999, 5, nil)),
+			wantOk: true, wantId: 0x9999, wantSeq: 5,
+			wantReason: FailReasonMtu, wantDetail: "来自192.0.2.1：需要分片，下一跳MTU为1400",
+		},
+		{
+			name:   "v4引用的ip头带选项",
+			b:      testIcmpError(icmpV4TypeDstUnreach, 1, 0, testIpv4Header(protoIcmp, 2), testEchoRequest(false, 0x9999, 6, nil)),
+			wantOk: true, wantId: 0x9999, wantSeq: 6,
+			wantReason: FailReasonUnreachable, wantDetail: "来自192.0.2.1：主机不可达",
+		},
+		{
+			name:   "v4被管理策略禁止",
+			b:      testIcmpError(icmpV4TypeDstUnreach, 13, 0, testIpv4Header(protoIcmp, 0), testEchoRequest(false, 1, 2, nil)),
+			wantOk: true, wantId: 1, wantSeq: 2,
+			wantReason: FailReasonProhibited, wantDetail: "来自192.0.2.1：被管理策略禁止",
+		},
+		{
+			name:   "v6包过大",
+			b:      testIcmpError(icmpV6TypePacketTooBig, 0, 1280, testIpv6Header(protoIcmpV6), testEchoRequest(true, 0x9999, 7, payload)),
+			v6:     true,
+			wantOk: true, wantId: 0x1234, wantSeq: 7, wantHasPayload: true,
+			wantReason: FailReasonMtu, wantDetail: "来自192.0.2.1：包过大，下一跳MTU为1280", wantPayloadTime: sendTime,
+		},
+		{
+			name:   "v6被管理策略禁止",
+			b:      testIcmpError(icmpV6TypeDstUnreach, 1, 0, testIpv6Header(protoIcmpV6), testEchoRequest(true, 8, 9, nil)),
+			v6:     true,
+			wantOk: true, wantId: 8, wantSeq: 9,
+			wantReason: FailReasonProhibited, wantDetail: "来自192.0.2.1：被管理策略禁止",
+		},
+		{
+			name: "v4引用的是udp",
+			b:    testIcmpError(icmpV4TypeDstUnreach, 3, 0, testIpv4Header(protoUdp, 0), make([]byte, 8)),
+		},
+		{
+			name: "v4引用的是echo回复",
+			b:    testIcmpError(icmpV4TypeTimeExceeded, 0, 0, testIpv4Header(protoIcmp, 0), append([]byte{icmpV4TypeEchoReply}, make([]byte, 7)...)),
+		},
+		{
+			name: "v6的差错报文按v4解析",
+			b:    testIcmpError(icmpV6TypeTimeExceeded, 0, 0, testIpv6Header(protoIcmpV6), testEchoRequest(true, 1, 2, nil)),
+		},
+		{
+			name: "v4的差错报文按v6解析",
+			b:    testIcmpError(icmpV4TypeTimeExceeded, 0, 0, testIpv4Header(protoIcmp, 0), testEchoRequest(false, 1, 2, nil)),
+			v6:   true,
+		},
+		{
+			name: "不是差错报文",
+			b:    testIcmpError(icmpV4TypeEchoReply, 0, 0, testIpv4Header(protoIcmp, 0), testEchoRequest(false, 1, 2, nil)),
+		},
+		{
+			name: "引用的echo头不完整",
+			b:    testIcmpError(icmpV4TypeTimeExceeded, 0, 0, testIpv4Header(protoIcmp, 0), testEchoRequest(false, 1, 2, nil)[:7]),
+		},
+		{
+			name: "太短",
+			b:    []byte{icmpV4TypeTimeExceeded, 0, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseIcmpError(tt.b, tt.v6, "192.0.2.1")
+			if ok != tt.wantOk {
+				t.Fatalf("ParseIcmpError() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if !ok {
+				return
+			}
+			if got.Id != tt.wantId || got.Seq != tt.wantSeq || got.HasPayload != tt.wantHasPayload {
+				t.Errorf("ParseIcmpError() id = %#x, seq = %d, hasPayload = %v, want %#x, %d, %v", got.Id, got.Seq, got.HasPayload, tt.wantId, tt.wantSeq, tt.wantHasPayload)
+			}
+			if got.Result.Reason != tt.wantReason || got.Result.Detail != tt.wantDetail {
+				t.Errorf("ParseIcmpError() result = %q %q, want %q %q", got.Result.Reason, got.Result.Detail, tt.wantReason, tt.wantDetail)
+			}
+			if tt.wantHasPayload && (!got.Payload.SendTime.Equal(tt.wantPayloadTime) || got.Payload.Round != 3) {
+				t.Errorf("ParseIcmpError() payload = %+v", got.Payload)
+			}
+		})
+	}
+}
+
+func TestQuotedTransport(t *testing.T) {
+	transport := []byte{0x4e, 0x20, 0x01, 0xbb, 1, 2, 3, 4}
+	tests := []struct {
+		name          string
+		quoted        []byte
+		v6            bool
+		wantOk        bool
+		wantProto     int
+		wantTransport []byte
+	}{
+		{"v4 udp", append(testIpv4Header(protoUdp, 0), transport...), false, true, protoUdp, transport},
+		{"v4 tcp带选项", append(testIpv4Header(protoTcp, 1), transport...), false, true, protoTcp, transport},
+		{"v4传输层头超过8字节", append(testIpv4Header(protoTcp, 0), append(transport, 5, 6)...), false, true, protoTcp, append(transport, 5, 6)},
+		{"v6 tcp", append(testIpv6Header(protoTcp), transport...), true, true, protoTcp, transport},
+		{"v4传输层头不足8字节", append(testIpv4Header(protoUdp, 0), transport[:7]...), false, false, 0, nil},
+		{"v6传输层头不足8字节", append(testIpv6Header(protoUdp), transport[:4]...), true, false, 0, nil},
+		{"v4首部长度小于20", append([]byte{4<<4 | 4}, make([]byte, 27)...), false, false, 0, nil},
+		{"v4首部长度超过报文", testIpv4Header(protoUdp, 1)[:22], false, false, 0, nil},
+		{"版本号不对", append(testIpv6Header(protoUdp), transport...), false, false, 0, nil},
+		{"v6版本号不对", append(testIpv4Header(protoUdp, 5), transport...), true, false, 0, nil},
+		{"空", nil, false, false, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proto, got, ok := quotedTransport(tt.quoted, tt.v6)
+			if ok != tt.wantOk || proto != tt.wantProto || !bytes.Equal(got, tt.wantTransport) {
+				t.Errorf("quotedTransport() = %d, %v, %v, want %d, %v, %v", proto, got, ok, tt.wantProto, tt.wantTransport, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"go_ping/utils"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+	"syscall"
+)
+
+// IcmpOption icmp打流的发包选项，同时作用于ipv4和ipv6的连接
+type IcmpOption struct {
+	Size    int    // 发包内容的字节数，不包含icmp头，0表示只发送时间戳、ID、进程随机数、轮次和固定内容
+	Pattern []byte // 固定内容之后循环填充的内容，为空时填充0
+	Ttl     int    // ipv4的TTL、ipv6的hop limit，0表示使用系统默认值
+	Df      bool   // 设置不分片，超过路径MTU的包会被丢弃而不是分片
+	Tos     int    // ipv4的TOS、ipv6的traffic class，0表示使用系统默认值
+}
+
+// icmp套接字类型
+var (
+	IcmpSockRaw   = "raw"   // 原始套接字，需要root权限
+	IcmpSockDgram = "dgram" // 非特权的icmp套接字，Linux需要net.ipv4.ping_group_range包含当前用户组
+)
+
+// icmp收包时间的来源
+var (
+	IcmpTimestampKernel = "kernel" // 内核收到包的时间，不包含在套接字缓冲区里排队的时间
+	IcmpTimestampUser   = "user"   // 收包协程读到包之后的时间，并发大时包含排队的时间
+)
+
+// IcmpEffectiveOption 连接上实际生效的发包选项，从内核读回
+type IcmpEffectiveOption struct {
+	Sock         string // 套接字类型，raw为原始套接字，dgram为非特权的icmp套接字
+	Size         int    // 发包内容的字节数
+	Ttl          int    // ipv4的TTL
+	HopLimit     int    // ipv6的hop limit
+	Df           bool   // ipv4和ipv6是否都设置了不分片
+	Tos          int    // ipv4的TOS
+	TrafficClass int    // ipv6的traffic class
+	Timestamp    string // 收包时间的来源，kernel为内核时间戳，user为用户态时间
+}
+
+// Apply 把发包选项设置到ipv4和ipv6的连接上，没有指定的选项保持系统默认值
+func (o *IcmpOption) Apply(handle net.PacketConn, handleV6 net.PacketConn) error {
+	p4 := ipv4.NewPacketConn(handle)
+	p6 := ipv6.NewPacketConn(handleV6)
+	if o.Ttl > 0 {
+		if err := p4.SetTTL(o.Ttl); err != nil {
+			return fmt.Errorf("设置TTL出错：%w", err)
+		}
+		if err := p6.SetHopLimit(o.Ttl); err != nil {
+			return fmt.Errorf("设置hop limit出错：%w", err)
+		}
+	}
+	if o.Tos > 0 {
+		if err := p4.SetTOS(o.Tos); err != nil {
+			return fmt.Errorf("设置TOS出错：%w", err)
+		}
+		if err := p6.SetTrafficClass(o.Tos); err != nil {
+			return fmt.Errorf("设置traffic class出错：%w", err)
+		}
+	}
+	if o.Df {
+		if err := setDontFragment(handle, false); err != nil {
+			return fmt.Errorf("设置不分片出错：%w", err)
+		}
+		if err := setDontFragment(handleV6, true); err != nil {
+			return fmt.Errorf("设置不分片出错：%w", err)
+		}
+	}
+	// 内核收包时间戳不是用户指定的选项，开启失败时退回用户态时间，不报错
+	for _, conn := range []net.PacketConn{handle, handleV6} {
+		if err := setKernelTimestamp(conn); err != nil {
+			utils.Log.Debugln("开启内核收包时间戳出错", err)
+		}
+	}
+	return nil
+}
+
+// Effective 从内核读回连接上实际生效的发包选项，读取失败的选项为0或false
+func (o *IcmpOption) Effective(handle net.PacketConn, handleV6 net.PacketConn) *IcmpEffectiveOption {
+	effective := &IcmpEffectiveOption{Sock: IcmpSockRaw, Size: o.PayloadSize()}
+	if IsIcmpDgram(handle) {
+		effective.Sock = IcmpSockDgram
+	}
+	effective.Ttl, _ = ipv4.NewPacketConn(handle).TTL()
+	effective.Tos, _ = ipv4.NewPacketConn(handle).TOS()
+	effective.HopLimit, _ = ipv6.NewPacketConn(handleV6).HopLimit()
+	effective.TrafficClass, _ = ipv6.NewPacketConn(handleV6).TrafficClass()
+	df, _ := getDontFragment(handle, false)
+	dfV6, _ := getDontFragment(handleV6, true)
+	effective.Df = df && dfV6
+	effective.Timestamp = IcmpTimestampUser
+	ts, _ := getKernelTimestamp(handle)
+	tsV6, _ := getKernelTimestamp(handleV6)
+	if ts && tsV6 {
+		effective.Timestamp = IcmpTimestampKernel
+	}
+	return effective
+}
+
+// String 展示用，比如sock=raw size=56 ttl=64 hop=64 df=on tos=0x00 tclass=0x00 ts=kernel
+func (e *IcmpEffectiveOption) String() string {
+	df := "off"
+	if e.Df {
+		df = "on"
+	}
+	return fmt.Sprintf("sock=%s size=%d ttl=%d hop=%d df=%s tos=0x%02x tclass=0x%02x ts=%s", e.Sock, e.Size, e.Ttl, e.HopLimit, df, e.Tos, e.TrafficClass, e.Timestamp)
+}
+
+// PayloadSize 发包内容实际的字节数，不足填充内容之前的长度时按这个长度发送
+func (o *IcmpOption) PayloadSize() int {
+	if o == nil || o.Size < icmpPayloadHeaderLen {
+		return icmpPayloadHeaderLen
+	}
+	return o.Size
+}
+
+// icmpReplyMinBufferSize 接收缓冲区的最小字节数，差错报文引用的原始包不受发包内容大小的限制
+const icmpReplyMinBufferSize = 1500
+
+// ReplyBufferSize 接收回复的缓冲区大小：ipv4最长的ip头（原始套接字收到的包带ip头）、icmp头加上发包内容，
+// 再多1个字节，收满时说明回复比发包内容长、被截断了
+func (o *IcmpOption) ReplyBufferSize() int {
+	size := 60 + 8 + o.PayloadSize() + 1
+	if size < icmpReplyMinBufferSize {
+		return icmpReplyMinBufferSize
+	}
+	return size
+}
+
+// fill 在时间戳和固定内容之后循环填充Pattern，补足Size字节
+func (o *IcmpOption) fill(payload []byte) []byte {
+	size := o.PayloadSize()
+	for i := 0; len(payload) < size; i++ {
+		if o == nil || len(o.Pattern) == 0 {
+			payload = append(payload, 0)
+			continue
+		}
+		payload = append(payload, o.Pattern[i%len(o.Pattern)])
+	}
+	return payload
+}
+
+// controlSocket 在连接的文件描述符上执行函数，用于设置x/net没有封装的套接字选项
+func controlSocket(conn net.PacketConn, f func(fd uintptr) error) error {
+	syscallConn, ok := conn.(syscall.Conn)
+	if !ok {
+		return errors.New("连接不支持设置套接字选项")
+	}
+	rawConn, err := syscallConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var controlErr error
+	if err = rawConn.Control(func(fd uintptr) {
+		controlErr = f(fd)
+	}); err != nil {
+		return err
+	}
+	return controlErr
+}
@@ -1,57 +1,139 @@
 package ping
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
 	"go_ping/utils"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
+	"math/rand"
 	"net"
 	"os"
 	"strings"
 	"time"
 )
 
-func GenSendHandle(srcIp string) (*icmp.PacketConn, error) {
+// icmpPayloadMagic 发包的固定内容，放在发送时间戳、ID、进程随机数和轮次后面
+var icmpPayloadMagic = []byte("HELLO-R-U-THERE")
+
+// icmpPayloadHeaderLen 发包内容里发送时间戳、ID、进程随机数、轮次和固定内容的长度，填充内容在这之后
+var icmpPayloadHeaderLen = 8 + 2 + 4 + 4 + len(icmpPayloadMagic)
+
+// icmpProcessNonce 本进程的随机数，同一台机器上多个go_ping的原始套接字都会收到所有的回复，据此区分是不是本进程发出的
+var icmpProcessNonce = rand.Uint32()
+
+// IcmpPayload 从回复内容中解析出的发包信息
+type IcmpPayload struct {
+	SendTime time.Time // 发送时间
+	Id       int       // 发包时的ID，非特权的icmp套接字会改写echo的ID
+	Round    int       // 发包时的轮次，持续打流时每一轮加1
+	Foreign  bool      // 不是本进程发出的，比如同一台机器上的其他go_ping
+}
+
+// GenSendHandle 创建ipv4的icmp连接，dgram为true时使用非特权的icmp套接字，否则使用原始套接字
+func GenSendHandle(srcIp string, dgram bool) (net.PacketConn, error) {
+	if dgram {
+		return genDgramHandle(srcIp, false)
+	}
 	// 使用特权模式监听ICMP数据包（需要管理员权限）
-	var c *icmp.PacketConn
+	var c net.PacketConn
 	var err error
 	if srcIp != "" {
 		// 创建监听用的地址对象
 		laddr := &net.IPAddr{IP: net.ParseIP(srcIp)}
 		// 创建ICMP监听
-		c, err = icmp.ListenPacket("ip4:icmp", laddr.String())
+		c, err = net.ListenPacket("ip4:icmp", laddr.String())
 	} else {
-		c, err = icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+		c, err = net.ListenPacket("ip4:icmp", "0.0.0.0")
 	}
 	if err != nil {
 		utils.Log.Errorln("创建ICMP监听", err)
 		os.Exit(1)
 	}
+	// 收包时带上TTL，用于估算跳数
+	enableReplyTtl(c, false)
 	return c, err
 }
 
 // GenSendHandleV6 支持ipv6监听
-func GenSendHandleV6(srcIp string) (*icmp.PacketConn, error) {
+func GenSendHandleV6(srcIp string, dgram bool) (net.PacketConn, error) {
+	if dgram {
+		return genDgramHandle(srcIp, true)
+	}
 	// 使用特权模式监听ICMP数据包（需要管理员权限）
-	var c *icmp.PacketConn
+	var c net.PacketConn
 	var err error
 	if srcIp != "" {
 		// 创建监听用的地址对象
 		laddr := &net.IPAddr{IP: net.ParseIP(srcIp)}
 		// 创建ICMP监听
-		c, err = icmp.ListenPacket("ip6:ipv6-icmp", laddr.String())
+		c, err = net.ListenPacket("ip6:ipv6-icmp", laddr.String())
 	} else {
-		c, err = icmp.ListenPacket("ip6:ipv6-icmp", "::") // 使用 "::" 作为本地地址，表示任意IPv6地址
+		c, err = net.ListenPacket("ip6:ipv6-icmp", "::") // 使用 "::" 作为本地地址，表示任意IPv6地址
 	}
 	if err != nil {
 		utils.Log.Errorln("创建ICMP监听", err)
 		os.Exit(1)
 	}
+	// 收包时带上hop limit，用于估算跳数
+	enableReplyTtl(c, true)
+	return c, err
+}
+
+// genDgramHandle 创建非特权的icmp连接，失败时提示用户
+func genDgramHandle(srcIp string, v6 bool) (net.PacketConn, error) {
+	c, err := listenIcmpDgram(srcIp, v6)
+	if err != nil {
+		utils.Log.Errorln("创建非特权ICMP监听", err)
+		fmt.Println("创建非特权icmp套接字失败，请以root(sudo)权限运行，或者通过sysctl设置net.ipv4.ping_group_range包含当前用户组：", err)
+		os.Exit(1)
+	}
+	enableReplyTtl(c, v6)
 	return c, err
 }
 
-// IcmpPingSend icmp ping发送函数，每个goroutines执行的
-func IcmpPingSend(dstIpOrDomain string, handle *icmp.PacketConn, handleV6 *icmp.PacketConn, icmpId int, icmpSeq int, icmpSendPkgInterval int) bool {
+// icmpDgramConn 非特权的icmp连接，收发使用UDPAddr，转换为和原始套接字一样的IPAddr
+type icmpDgramConn struct {
+	*net.UDPConn
+}
+
+// ReadFrom 收包，对端地址转换为IPAddr
+func (c *icmpDgramConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.UDPConn.ReadFromUDP(b)
+	if addr == nil {
+		return n, nil, err
+	}
+	return n, &net.IPAddr{IP: addr.IP, Zone: addr.Zone}, err
+}
+
+// ReadMsgIP 收包和控制消息，对端地址转换为IPAddr，和原始套接字的*net.IPConn一致
+func (c *icmpDgramConn) ReadMsgIP(b []byte, oob []byte) (int, int, int, *net.IPAddr, error) {
+	n, oobn, flags, addr, err := c.UDPConn.ReadMsgUDP(b, oob)
+	if addr == nil {
+		return n, oobn, flags, nil, err
+	}
+	return n, oobn, flags, &net.IPAddr{IP: addr.IP, Zone: addr.Zone}, err
+}
+
+// WriteTo 发包，目标地址转换为UDPAddr，端口无意义
+func (c *icmpDgramConn) WriteTo(b []byte, dst net.Addr) (int, error) {
+	if ipAddr, ok := dst.(*net.IPAddr); ok {
+		dst = &net.UDPAddr{IP: ipAddr.IP, Zone: ipAddr.Zone}
+	}
+	return c.UDPConn.WriteTo(b, dst)
+}
+
+// IsIcmpDgram 是否是非特权的icmp连接，内核会把echo的ID改为套接字自己的ID
+func IsIcmpDgram(c net.PacketConn) bool {
+	_, ok := c.(*icmpDgramConn)
+	return ok
+}
+
+// IcmpPingSend icmp ping发送函数，每个goroutines执行的，option为nil时只发送时间戳和固定内容
+// 发包成功返回Success为true的结果，是否收到回复由收包函数判断；发包失败返回失败原因
+func IcmpPingSend(dstIpOrDomain string, handle net.PacketConn, handleV6 net.PacketConn, icmpId int, icmpSeq int, icmpSendPkgInterval int, round int, option *IcmpOption) PingResult {
 	// 目标地址
 	netType := "ip4"
 	if strings.Contains(dstIpOrDomain, ":") {
@@ -60,9 +142,11 @@ func IcmpPingSend(dstIpOrDomain string, handle *icmp.PacketConn, handleV6 *icmp.
 	dst, err := net.ResolveIPAddr(netType, dstIpOrDomain)
 	if err != nil {
 		utils.Log.Errorln("目标地址出错", err)
-		return false
+		return failResult(err)
 	}
 
+	// 发送时间戳放在发包内容里，收包时据此计算时延
+	payload := genIcmpPayload(time.Now(), icmpId, round, option)
 	// 创建一个ICMP消息
 	message := icmp.Message{
 		Type: ipv4.ICMPTypeEcho, // ICMP回显请求
@@ -70,7 +154,7 @@ func IcmpPingSend(dstIpOrDomain string, handle *icmp.PacketConn, handleV6 *icmp.
 		Body: &icmp.Echo{
 			ID:   icmpId,  // 使用进程ID作为标识符，0 到 65535（2^16 - 1）
 			Seq:  icmpSeq, // 序列号，0 到 65535（2^16 - 1）
-			Data: []byte("HELLO-R-U-THERE"),
+			Data: payload,
 		},
 	}
 	// ipv6
@@ -81,7 +165,7 @@ func IcmpPingSend(dstIpOrDomain string, handle *icmp.PacketConn, handleV6 *icmp.
 			Body: &icmp.Echo{
 				ID:   icmpId,  // 使用进程ID作为标识符，0 到 65535（2^16 - 1）
 				Seq:  icmpSeq, // 序列号，0 到 65535（2^16 - 1）
-				Data: []byte("HELLO-R-U-THERE"),
+				Data: payload,
 			},
 		}
 	}
@@ -90,22 +174,47 @@ func IcmpPingSend(dstIpOrDomain string, handle *icmp.PacketConn, handleV6 *icmp.
 	binaryMessage, err := message.Marshal(nil)
 	if err != nil {
 		utils.Log.Errorln("将ICMP消息编码为字节出错", err)
-		return false
+		return failResult(err)
 	}
 
 	// 发送消息
 	if strings.Contains(dstIpOrDomain, ":") {
 		if _, err = handleV6.WriteTo(binaryMessage, dst); err != nil {
 			utils.Log.Errorln("发送消息出错", err)
-			return false
+			return failResult(err)
 		}
 	} else {
 		if _, err = handle.WriteTo(binaryMessage, dst); err != nil {
 			utils.Log.Errorln("发送消息出错", err)
-			return false
+			return failResult(err)
 		}
 	}
 	utils.Log.Traceln("success send icmp to", dst, time.Now().String())
 	time.Sleep(time.Duration(icmpSendPkgInterval) * time.Millisecond)
-	return true
+	return PingResult{Success: true}
+}
+
+// genIcmpPayload 生成icmp发包内容，整数都是大端序：
+// 8字节发送时间戳（UnixNano）+ 2字节ID + 4字节进程随机数 + 4字节轮次 + 固定内容 + 填充内容
+// 非特权的icmp套接字会改写echo的ID，回复里的ID以发包内容里的为准
+func genIcmpPayload(sendTime time.Time, icmpId int, round int, option *IcmpOption) []byte {
+	payload := make([]byte, 18, option.PayloadSize())
+	binary.BigEndian.PutUint64(payload, uint64(sendTime.UnixNano()))
+	binary.BigEndian.PutUint16(payload[8:], uint16(icmpId))
+	binary.BigEndian.PutUint32(payload[10:], icmpProcessNonce)
+	binary.BigEndian.PutUint32(payload[14:], uint32(round))
+	return option.fill(append(payload, icmpPayloadMagic...))
+}
+
+// ParseIcmpPayload 从icmp回复内容中解析发包信息，内容不是go_ping发出的返回false
+func ParseIcmpPayload(data []byte) (IcmpPayload, bool) {
+	if len(data) < icmpPayloadHeaderLen || !bytes.Equal(data[18:icmpPayloadHeaderLen], icmpPayloadMagic) {
+		return IcmpPayload{}, false
+	}
+	return IcmpPayload{
+		SendTime: time.Unix(0, int64(binary.BigEndian.Uint64(data[:8]))),
+		Id:       int(binary.BigEndian.Uint16(data[8:10])),
+		Round:    int(binary.BigEndian.Uint32(data[14:18])),
+		Foreign:  binary.BigEndian.Uint32(data[10:14]) != icmpProcessNonce,
+	}, true
 }
@@ -0,0 +1,82 @@
+package ping
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestParseIcmpPayload(t *testing.T) {
+	sendTime := time.Unix(1700000000, 123456789)
+	payload := genIcmpPayload(sendTime, 0x1234, 7, nil)
+	padded := genIcmpPayload(sendTime, 0x1234, 7, &IcmpOption{Size: 64, Pattern: []byte{0xab}})
+	// 同一台机器上其他go_ping发出的，进程随机数不同
+	foreign := genIcmpPayload(sendTime, 0x1234, 7, nil)
+	binary.BigEndian.PutUint32(foreign[10:14], icmpProcessNonce+1)
+	// 固定内容被改了，不是go_ping发出的
+	badMagic := genIcmpPayload(sendTime, 0x1234, 7, nil)
+	badMagic[18] ^= 0xff
+	tests := []struct {
+		name   string
+		data   []byte
+		want   IcmpPayload
+		wantOk bool
+	}{
+		{"本进程", payload, IcmpPayload{SendTime: sendTime, Id: 0x1234, Round: 7}, true},
+		{"带填充内容", padded, IcmpPayload{SendTime: sendTime, Id: 0x1234, Round: 7}, true},
+		{"其他go_ping", foreign, IcmpPayload{SendTime: sendTime, Id: 0x1234, Round: 7, Foreign: true}, true},
+		{"固定内容不对", badMagic, IcmpPayload{}, false},
+		{"太短", payload[:icmpPayloadHeaderLen-1], IcmpPayload{}, false},
+		{"系统ping", []byte("abcdefghijklmnopqrstuvwabcdefghi"), IcmpPayload{}, false},
+		{"空", nil, IcmpPayload{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseIcmpPayload(tt.data)
+			if ok != tt.wantOk || !got.SendTime.Equal(tt.want.SendTime) || got.Id != tt.want.Id || got.Round != tt.want.Round || got.Foreign != tt.want.Foreign {
+				t.Errorf("ParseIcmpPayload() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestGenIcmpPayloadSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		option   *IcmpOption
+		wantLen  int
+		wantTail byte
+	}{
+		{"默认大小", nil, icmpPayloadHeaderLen, icmpPayloadMagic[len(icmpPayloadMagic)-1]},
+		{"小于最小值按最小值", &IcmpOption{Size: 10}, icmpPayloadHeaderLen, icmpPayloadMagic[len(icmpPayloadMagic)-1]},
+		{"补0", &IcmpOption{Size: 100}, 100, 0},
+		{"循环填充", &IcmpOption{Size: icmpPayloadHeaderLen + 3, Pattern: []byte{1, 2}}, icmpPayloadHeaderLen + 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := genIcmpPayload(time.Now(), 1, 0, tt.option)
+			if len(got) != tt.wantLen || got[len(got)-1] != tt.wantTail {
+				t.Errorf("genIcmpPayload() len = %d, tail = %#x, want %d, %#x", len(got), got[len(got)-1], tt.wantLen, tt.wantTail)
+			}
+		})
+	}
+}
+
+func TestIcmpReplyBufferSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		option *IcmpOption
+		want   int
+	}{
+		{"默认大小不小于1500", nil, 1500},
+		{"1400字节不小于1500", &IcmpOption{Size: 1400}, 1500},
+		{"大包加上ip头、icmp头和1个字节", &IcmpOption{Size: 65507}, 60 + 8 + 65507 + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.option.ReplyBufferSize(); got != tt.want {
+				t.Errorf("ReplyBufferSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+//go:build darwin
+
+package ping
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// macOS的不分片选项，syscall包里没有定义
+const (
+	ipDontFrag   = 0x1c // IP_DONTFRAG
+	ipv6DontFrag = 0x3e // IPV6_DONTFRAG
+)
+
+// setDontFragment macOS通过IP_DONTFRAG、IPV6_DONTFRAG设置不分片
+func setDontFragment(conn net.PacketConn, v6 bool) error {
+	return controlSocket(conn, func(fd uintptr) error {
+		if v6 {
+			return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, ipv6DontFrag, 1)
+		}
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, ipDontFrag, 1)
+	})
+}
+
+// getDontFragment 读取是否设置了不分片
+func getDontFragment(conn net.PacketConn, v6 bool) (bool, error) {
+	var value int
+	err := controlSocket(conn, func(fd uintptr) error {
+		var err error
+		if v6 {
+			value, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IPV6, ipv6DontFrag)
+			return err
+		}
+		value, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, ipDontFrag)
+		return err
+	})
+	return err == nil && value != 0, err
+}
+
+// setKernelTimestamp macOS通过SO_TIMESTAMP开启内核收包时间戳，精度为微秒
+func setKernelTimestamp(conn net.PacketConn) error {
+	return controlSocket(conn, func(fd uintptr) error {
+		return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMP, 1)
+	})
+}
+
+// getKernelTimestamp 读取是否开启了内核收包时间戳
+func getKernelTimestamp(conn net.PacketConn) (bool, error) {
+	var value int
+	err := controlSocket(conn, func(fd uintptr) error {
+		var err error
+		value, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMP)
+		return err
+	})
+	return err == nil && value != 0, err
+}
+
+// kernelReceiveTime 从控制消息里取出SCM_TIMESTAMP的收包时间
+func kernelReceiveTime(oob []byte) (time.Time, bool) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, m := range messages {
+		if m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SCM_TIMESTAMP && len(m.Data) >= int(unsafe.Sizeof(syscall.Timeval{})) {
+			tv := (*syscall.Timeval)(unsafe.Pointer(&m.Data[0]))
+			return time.Unix(tv.Unix()), true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,68 @@
+//go:build linux
+
+package ping
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// setDontFragment Linux通过IP_MTU_DISCOVER设置为IP_PMTUDISC_DO，发出的包带DF标志且不在本机分片
+func setDontFragment(conn net.PacketConn, v6 bool) error {
+	return controlSocket(conn, func(fd uintptr) error {
+		if v6 {
+			return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+		}
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+	})
+}
+
+// getDontFragment 读取是否设置了不分片
+func getDontFragment(conn net.PacketConn, v6 bool) (bool, error) {
+	var value int
+	err := controlSocket(conn, func(fd uintptr) error {
+		var err error
+		if v6 {
+			value, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER)
+			return err
+		}
+		value, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER)
+		return err
+	})
+	return err == nil && value == syscall.IP_PMTUDISC_DO, err
+}
+
+// setKernelTimestamp Linux通过SO_TIMESTAMPNS开启内核收包时间戳，精度为纳秒
+func setKernelTimestamp(conn net.PacketConn) error {
+	return controlSocket(conn, func(fd uintptr) error {
+		return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
+	})
+}
+
+// getKernelTimestamp 读取是否开启了内核收包时间戳
+func getKernelTimestamp(conn net.PacketConn) (bool, error) {
+	var value int
+	err := controlSocket(conn, func(fd uintptr) error {
+		var err error
+		value, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS)
+		return err
+	})
+	return err == nil && value != 0, err
+}
+
+// kernelReceiveTime 从控制消息里取出SCM_TIMESTAMPNS的收包时间
+func kernelReceiveTime(oob []byte) (time.Time, bool) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, m := range messages {
+		if m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SCM_TIMESTAMPNS && len(m.Data) >= int(unsafe.Sizeof(syscall.Timespec{})) {
+			ts := (*syscall.Timespec)(unsafe.Pointer(&m.Data[0]))
+			return time.Unix(ts.Unix()), true
+		}
+	}
+	return time.Time{}, false
+}
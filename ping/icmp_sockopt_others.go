@@ -0,0 +1,40 @@
+//go:build !linux && !darwin
+
+package ping
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// errDontFragmentUnsupported 其他系统不支持设置不分片
+var errDontFragmentUnsupported = errors.New("当前系统不支持设置不分片")
+
+// errKernelTimestampUnsupported 其他系统不支持内核收包时间戳
+var errKernelTimestampUnsupported = errors.New("当前系统不支持内核收包时间戳")
+
+// setDontFragment 其他系统不支持设置不分片
+func setDontFragment(conn net.PacketConn, v6 bool) error {
+	return errDontFragmentUnsupported
+}
+
+// getDontFragment 其他系统不支持设置不分片
+func getDontFragment(conn net.PacketConn, v6 bool) (bool, error) {
+	return false, errDontFragmentUnsupported
+}
+
+// setKernelTimestamp 其他系统不支持内核收包时间戳
+func setKernelTimestamp(conn net.PacketConn) error {
+	return errKernelTimestampUnsupported
+}
+
+// getKernelTimestamp 其他系统不支持内核收包时间戳
+func getKernelTimestamp(conn net.PacketConn) (bool, error) {
+	return false, errKernelTimestampUnsupported
+}
+
+// kernelReceiveTime 其他系统不支持内核收包时间戳
+func kernelReceiveTime(oob []byte) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -0,0 +1,93 @@
+package ping
+
+import (
+	"go_ping/utils"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+	"time"
+)
+
+// 常见系统发包的初始TTL，Linux、macOS为64，Windows为128，网络设备为255
+var initialTtlList = []int{64, 128, 255}
+
+// PacketMeta 收包时从ip头或者控制消息里读到的信息
+type PacketMeta struct {
+	ReceiveTime time.Time // 收包时间，开启了内核时间戳时为内核收到包的时间
+	Ttl         int       // 回复的TTL（ipv6为hop limit），读不到时为0
+	Truncated   bool      // 收满了缓冲区，回复可能被截断了
+}
+
+// msgReader 能同时读到控制消息的连接，原始套接字和非特权的icmp套接字都支持
+type msgReader interface {
+	ReadMsgIP(b []byte, oob []byte) (int, int, int, *net.IPAddr, error)
+}
+
+// ReadPacket 收包并返回收包时间和TTL，oob为空时只能使用读到包之后的时间，也读不到TTL
+// oob不为空时从控制消息里读取内核收包时间戳和TTL，ipv4原始套接字从ip头里读取TTL
+func ReadPacket(c net.PacketConn, b []byte, oob []byte) (int, net.Addr, PacketMeta, error) {
+	reader, ok := c.(msgReader)
+	if !ok || len(oob) == 0 {
+		n, peer, err := c.ReadFrom(b)
+		return n, peer, PacketMeta{ReceiveTime: time.Now(), Truncated: n == len(b)}, err
+	}
+	n, oobn, _, peer, err := reader.ReadMsgIP(b, oob)
+	meta := PacketMeta{ReceiveTime: time.Now(), Truncated: n == len(b)}
+	// ipv4原始套接字的ReadMsgIP不会像ReadFrom一样去掉ip头
+	if _, raw := c.(*net.IPConn); raw && n >= ipv4.HeaderLen && b[0]>>4 == 4 {
+		headerLen := int(b[0]&0x0f) * 4
+		if headerLen >= ipv4.HeaderLen && headerLen <= n {
+			meta.Ttl = int(b[8])
+			n = copy(b, b[headerLen:n])
+		}
+	}
+	if kernelTime, ok := kernelReceiveTime(oob[:oobn]); ok {
+		meta.ReceiveTime = kernelTime
+	}
+	if meta.Ttl == 0 {
+		meta.Ttl = controlMessageTtl(oob[:oobn])
+	}
+	if peer == nil {
+		return n, nil, meta, err
+	}
+	return n, peer, meta, err
+}
+
+// controlMessageTtl 从控制消息里取出ipv4的TTL或者ipv6的hop limit，没有时为0
+func controlMessageTtl(oob []byte) int {
+	var cm4 ipv4.ControlMessage
+	if cm4.Parse(oob) == nil && cm4.TTL > 0 {
+		return cm4.TTL
+	}
+	var cm6 ipv6.ControlMessage
+	if cm6.Parse(oob) == nil && cm6.HopLimit > 0 {
+		return cm6.HopLimit
+	}
+	return 0
+}
+
+// enableReplyTtl 开启收包时带上TTL（ipv6为hop limit）的控制消息，用于估算到目标的跳数，开启失败时不报错
+func enableReplyTtl(conn net.PacketConn, v6 bool) {
+	var err error
+	if v6 {
+		err = ipv6.NewPacketConn(conn).SetControlMessage(ipv6.FlagHopLimit, true)
+	} else {
+		err = ipv4.NewPacketConn(conn).SetControlMessage(ipv4.FlagTTL, true)
+	}
+	if err != nil {
+		utils.Log.Debugln("开启收包TTL出错", err)
+	}
+}
+
+// EstimateHops 根据回复的TTL估算到目标的跳数，初始TTL取不小于回复TTL的最小常见值，ttl为0时返回-1
+func EstimateHops(ttl int) int {
+	if ttl <= 0 {
+		return -1
+	}
+	for _, initialTtl := range initialTtlList {
+		if ttl <= initialTtl {
+			return initialTtl - ttl
+		}
+	}
+	return -1
+}
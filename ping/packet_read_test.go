@@ -0,0 +1,67 @@
+package ping
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEstimateHops(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  int
+		want int
+	}{
+		{"初始64本机", 64, 0},
+		{"初始64经过10跳", 54, 10},
+		{"初始64经过63跳", 1, 63},
+		{"初始128", 118, 10},
+		{"刚超过64按128", 65, 63},
+		{"初始255", 245, 10},
+		{"刚超过128按255", 129, 126},
+		{"255", 255, 0},
+		{"读不到TTL", 0, -1},
+		{"负数", -1, -1},
+		{"超过255", 256, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateHops(tt.ttl); got != tt.want {
+				t.Errorf("EstimateHops(%d) = %d, want %d", tt.ttl, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPacketTruncated(t *testing.T) {
+	tests := []struct {
+		name          string
+		bufferSize    int
+		wantN         int
+		wantTruncated bool
+	}{
+		{"缓冲区够大", 11, 10, false},
+		{"正好收满", 10, 10, true},
+		{"被截断", 5, 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if _, err = c.WriteTo(make([]byte, 10), c.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			_ = c.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, meta, err := ReadPacket(c, make([]byte, tt.bufferSize), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.wantN || meta.Truncated != tt.wantTruncated {
+				t.Errorf("ReadPacket() n = %d, truncated = %v, want %d, %v", n, meta.Truncated, tt.wantN, tt.wantTruncated)
+			}
+		})
+	}
+}
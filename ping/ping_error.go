@@ -0,0 +1,85 @@
+package ping
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// 失败原因分类
+var (
+	FailReasonDns          = "dns"            // 域名解析失败
+	FailReasonRefused      = "refused"        // 连接被拒绝，即收到RST，一般是端口没有监听
+	FailReasonTimeout      = "timeout"        // 超时，一般是丢包或者被防火墙丢弃
+	FailReasonFiltered     = "filtered"       // udp、tcp-syn超时没有任何回复，可能是被防火墙丢弃，也可能是服务不回复
+	FailReasonUnreachable  = "unreachable"    // 主机或网络不可达，icmp打流还包括路由器返回的协议、端口不可达
+	FailReasonTtlExceeded  = "ttl_exceeded"   // icmp打流路由器返回TTL（ipv6的hop limit）超时，一般是路由环路或者TTL设置太小
+	FailReasonProhibited   = "prohibited"     // icmp打流路由器返回被管理策略禁止，一般是ACL拦截
+	FailReasonTls          = "tls"            // TLS握手或证书校验失败
+	FailReasonHttpStatus   = "http_status"    // HTTP状态码不在允许的范围内
+	FailReasonHttpHeader   = "http_header"    // HTTP响应缺少要求的头，或者头的值不对
+	FailReasonHttpBody     = "http_body"      // HTTP响应体不包含要求的字符串，或者不匹配要求的正则
+	FailReasonHttpBodySize = "http_body_size" // HTTP响应体超过最大字节数
+	FailReasonHttpRedirect = "http_redirect"  // HTTP跳转超过最多跳转次数，或者跳转策略为fail时收到跳转的响应
+	FailReasonTcpExpect    = "tcp_expect"     // tcp打流没有收到符合预期的响应，比如进程卡死只有内核在建连
+	FailReasonCertExpired  = "cert_expired"   // 证书已过期或者还没有生效
+	FailReasonCertChain    = "cert_chain"     // 证书链不可信
+	FailReasonCertName     = "cert_name"      // 证书的SAN和SNI或者目标IP不匹配
+	FailReasonDnsRcode     = "dns_rcode"      // dns应答码不是NOERROR
+	FailReasonDnsMismatch  = "dns_mismatch"   // dns应答记录和期望的值不一致
+	FailReasonLocal        = "local"          // 本机资源耗尽，比如源端口、文件句柄、缓冲区不够，不是目标的问题
+	FailReasonMtu          = "mtu"            // 设置了不分片，包超过本机出口或者已知的路径MTU发不出去，或者路由器返回需要分片
+	FailReasonTruncated    = "truncated"      // icmp打流的回复比发包内容短，或者超过接收缓冲区被截断
+	FailReasonOther        = "other"          // 其他错误
+)
+
+// ClassifyError 根据错误判断失败原因
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return FailReasonTimeout
+		}
+		return FailReasonDns
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		return FailReasonRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return FailReasonUnreachable
+	case errors.Is(err, syscall.EADDRNOTAVAIL), errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE), errors.Is(err, syscall.ENOBUFS):
+		return FailReasonLocal
+	case errors.Is(err, syscall.EMSGSIZE):
+		return FailReasonMtu
+	}
+	if errors.Is(err, errHttpRedirect) {
+		return FailReasonHttpRedirect
+	}
+	if isTlsError(err) {
+		return FailReasonTls
+	}
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return FailReasonTimeout
+	}
+	return FailReasonOther
+}
+
+// isTlsError 判断是否是TLS握手或证书校验的错误
+func isTlsError(err error) bool {
+	var recordHeaderErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalidErr x509.CertificateInvalidError
+	return errors.As(err, &recordHeaderErr) || errors.As(err, &alertErr) || errors.As(err, &verifyErr) ||
+		errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &certInvalidErr)
+}
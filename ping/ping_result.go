@@ -0,0 +1,26 @@
+package ping
+
+import "time"
+
+// PingResult 一次探测的结果
+type PingResult struct {
+	Success   bool          // 是否成功
+	Rtt       time.Duration // 往返时延：tcp为建连时间，tls为握手时间，http为整个请求时间，icmp为发包到收到回复的时间；失败时为0
+	Reason    string        // 失败原因，取值见FailReason开头的变量；成功时为空
+	Dns       *DnsResult    // dns打流的应答，其他打流类型为nil
+	Tls       *TlsResult    // tls打流的证书信息，握手失败或者其他打流类型为nil
+	Detail    string        // 失败详情，比如http响应哪一项校验没有通过
+	Http      *HttpTiming   // http打流每个阶段的耗时，没有收到响应时为nil
+	Redirects []string      // http打流依次跳转到的url，没有跳转时为nil
+	Ttl       int           // icmp、tcp-syn打流收到的回复的TTL（ipv6为hop limit），读不到时为0
+	Silent    bool          // udp打流没有任何回复、按--udp.silence.ok算成功，端口状态为open|filtered，没有时延
+}
+
+// failResult 根据错误生成失败的结果
+func failResult(err error) PingResult {
+	reason := ClassifyError(err)
+	if reason == "" {
+		reason = FailReasonOther
+	}
+	return PingResult{Reason: reason}
+}
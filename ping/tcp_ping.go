@@ -1,15 +1,49 @@
 package ping
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"go_ping/utils"
+	"io"
 	"net"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// TcpOption tcp打流的选项，多个任务共用，只读
+type TcpOption struct {
+	Send        []byte         // 建连后发送的内容，为空时不发送
+	Expect      []byte         // 响应需要包含的内容
+	ExpectRegex *regexp.Regexp // 响应需要匹配的正则
+	ReadTimeout time.Duration  // 等待响应的超时时间，0表示使用打流的超时时间
+	CloseRst    bool           // 使用RST关闭连接（SO_LINGER 0），不产生TIME_WAIT，高频打流时避免源端口耗尽
+}
+
+// needRead 是否需要读取响应进行校验
+func (o *TcpOption) needRead() bool {
+	return o != nil && (len(o.Expect) > 0 || o.ExpectRegex != nil)
+}
+
+// match 响应是否符合预期
+func (o *TcpOption) match(response []byte) bool {
+	if len(o.Expect) > 0 && !bytes.Contains(response, o.Expect) {
+		return false
+	}
+	if o.ExpectRegex != nil && !o.ExpectRegex.Match(response) {
+		return false
+	}
+	return true
+}
+
+// tcpMaxResponseSize 最多读取的响应字节数，超过还没有匹配就算失败
+const tcpMaxResponseSize = 64 * 1024
+
 // TcpPing tcp ping原子函数，每个goroutines执行的
-func TcpPing(dstIpOrDomain string, dstPort int, timeout int, srcIp string) bool {
+// option为nil时只建连，时延为建连时间；指定了期望的响应时，收到符合预期的响应才算成功，时延为建连到收到符合预期的响应的时间
+func TcpPing(dstIpOrDomain string, dstPort int, timeout int, srcIp string, option *TcpOption) PingResult {
 	// 目标地址
 	dstAddress := net.JoinHostPort(dstIpOrDomain, fmt.Sprintf("%d", dstPort))
 	// 指定超时时间
@@ -27,25 +61,94 @@ func TcpPing(dstIpOrDomain string, dstPort int, timeout int, srcIp string) bool
 		srcTCPAddress, err := net.ResolveTCPAddr("tcp", srcAddress)
 		if err != nil {
 			utils.Log.Errorln(err)
-			return false
+			return failResult(err)
 		}
 		d = net.Dialer{
 			LocalAddr: srcTCPAddress,
 			Timeout:   duration,
 		}
 	}
+	startTime := time.Now()
 	conn, err := d.Dial("tcp", dstAddress)
-	result := true
+	// 建连时间
+	result := PingResult{Success: true, Rtt: time.Since(startTime)}
 	if err != nil {
 		utils.Log.Traceln(err)
-		result = false
+		result = failResult(err)
+	} else if option != nil {
+		// 发送内容，校验响应
+		result = tcpExchange(conn, option, duration, startTime)
 	}
 	if conn != nil {
+		if option != nil && option.CloseRst {
+			if tcpConn, ok := conn.(*net.TCPConn); ok {
+				if err1 := tcpConn.SetLinger(0); err1 != nil {
+					utils.Log.Traceln(err1)
+				}
+			}
+		}
 		err1 := conn.Close()
-		if err1 != nil {
+		if err1 != nil && result.Success {
 			utils.Log.Traceln(err1)
-			result = false
+			result = failResult(err1)
 		}
 	}
 	return result
 }
+
+// tcpExchange 建连后发送内容，读取响应直到符合预期、超时、对端关闭或者超过最多读取的字节数
+func tcpExchange(conn net.Conn, option *TcpOption, timeout time.Duration, startTime time.Time) PingResult {
+	if len(option.Send) > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return failResult(err)
+		}
+		if _, err := conn.Write(option.Send); err != nil {
+			utils.Log.Traceln(err)
+			return failResult(err)
+		}
+	}
+	if !option.needRead() {
+		return PingResult{Success: true, Rtt: time.Since(startTime)}
+	}
+	readTimeout := option.ReadTimeout
+	if readTimeout <= 0 {
+		readTimeout = timeout
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+		return failResult(err)
+	}
+	var response []byte
+	buffer := make([]byte, 4096)
+	for len(response) < tcpMaxResponseSize {
+		n, err := conn.Read(buffer)
+		response = append(response, buffer[:n]...)
+		if option.match(response) {
+			return PingResult{Success: true, Rtt: time.Since(startTime)}
+		}
+		if err == nil {
+			continue
+		}
+		utils.Log.Traceln(err)
+		if len(response) > 0 {
+			// 收到了响应但是不符合预期
+			break
+		}
+		if errors.Is(err, io.EOF) {
+			return PingResult{Reason: FailReasonTcpExpect, Detail: "连接被对端关闭，没有收到响应"}
+		}
+		// 建连成功但是一直没有响应，一般是进程卡死，只有内核在建连
+		if ClassifyError(err) == FailReasonTimeout {
+			return PingResult{Reason: FailReasonTcpExpect, Detail: "建连成功，等待响应超时"}
+		}
+		return failResult(err)
+	}
+	return PingResult{Reason: FailReasonTcpExpect, Detail: fmt.Sprintf("响应不符合预期：%s", responsePreview(response))}
+}
+
+// responsePreview 失败详情里展示的响应，最多展示64个字节，不可见字符转义
+func responsePreview(response []byte) string {
+	if len(response) > 64 {
+		return strconv.Quote(string(response[:64])) + "..."
+	}
+	return strconv.Quote(string(response))
+}
@@ -0,0 +1,190 @@
+package ping
+
+import (
+	"encoding/binary"
+	"go_ping/utils"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tcp报文的标志位
+const (
+	tcpFlagSyn = 0x02
+	tcpFlagRst = 0x04
+	tcpFlagAck = 0x10
+)
+
+// tcpSynWindow SYN报文的窗口大小
+const tcpSynWindow = 64240
+
+// tcpSynMss SYN报文带的MSS选项
+const tcpSynMss = 1460
+
+// tcpSynSrcIpCache 目标IP到本机出口IP的缓存，计算校验和需要源IP，持续打流时不用每轮都查路由
+var tcpSynSrcIpCache sync.Map
+
+// TcpSynReply 收到的对SYN的回复，SYN-ACK表示端口开放，RST表示端口关闭
+type TcpSynReply struct {
+	SrcPort int    // 目标端口
+	DstPort int    // 发SYN时使用的源端口
+	Seq     uint32 // 发SYN时使用的序列号，即回复的确认号减1
+	Open    bool   // 收到SYN-ACK为true，收到RST为false
+}
+
+// GenTcpSynHandle 创建收发tcp报文的原始套接字（需要root权限），内核负责填写ip头
+func GenTcpSynHandle(srcIp string) (*net.IPConn, error) {
+	var laddr *net.IPAddr
+	if srcIp != "" {
+		laddr = &net.IPAddr{IP: net.ParseIP(srcIp)}
+	}
+	c, err := net.ListenIP("ip4:tcp", laddr)
+	if err != nil {
+		utils.Log.Errorln("创建tcp原始套接字", err)
+		os.Exit(1)
+	}
+	// 收包时带上TTL，用于估算跳数
+	enableReplyTtl(c, false)
+	return c, err
+}
+
+// GenTcpSynHandleV6 支持ipv6
+func GenTcpSynHandleV6(srcIp string) (*net.IPConn, error) {
+	var laddr *net.IPAddr
+	if srcIp != "" {
+		laddr = &net.IPAddr{IP: net.ParseIP(srcIp)}
+	}
+	c, err := net.ListenIP("ip6:tcp", laddr)
+	if err != nil {
+		utils.Log.Errorln("创建tcp原始套接字", err)
+		os.Exit(1)
+	}
+	// 收包时带上hop limit，用于估算跳数
+	enableReplyTtl(c, true)
+	return c, err
+}
+
+// GenTcpSynPacket 生成发往目标的SYN报文，不包含ip头，校验和按照本机出口IP计算
+func GenTcpSynPacket(dstIpOrDomain string, dstPort int, srcIp string, srcPort int, seq uint32) (*net.IPAddr, []byte, error) {
+	netType := "ip4"
+	if strings.Contains(dstIpOrDomain, ":") {
+		netType = "ip6"
+	}
+	dst, err := net.ResolveIPAddr(netType, dstIpOrDomain)
+	if err != nil {
+		utils.Log.Errorln("目标地址出错", err)
+		return nil, nil, err
+	}
+	src, err := tcpSynSrcIp(dst.IP, dstPort, srcIp)
+	if err != nil {
+		return nil, nil, err
+	}
+	packet := make([]byte, 24)
+	binary.BigEndian.PutUint16(packet[0:2], uint16(srcPort))
+	binary.BigEndian.PutUint16(packet[2:4], uint16(dstPort))
+	binary.BigEndian.PutUint32(packet[4:8], seq)
+	// 首部长度24字节，6个32位字
+	packet[12] = 6 << 4
+	packet[13] = tcpFlagSyn
+	binary.BigEndian.PutUint16(packet[14:16], tcpSynWindow)
+	// MSS选项
+	packet[20] = 2
+	packet[21] = 4
+	binary.BigEndian.PutUint16(packet[22:24], tcpSynMss)
+	binary.BigEndian.PutUint16(packet[16:18], tcpChecksum(src, dst.IP, packet))
+	return dst, packet, nil
+}
+
+// TcpSynSend tcp-syn打流发送函数，每个goroutines执行的
+// 发包成功返回Success为true的结果，是否收到回复由收包函数判断；发包失败返回失败原因
+func TcpSynSend(dst *net.IPAddr, packet []byte, handle *net.IPConn, handleV6 *net.IPConn, sendPkgInterval int) PingResult {
+	conn := handle
+	if dst.IP.To4() == nil {
+		conn = handleV6
+	}
+	if _, err := conn.WriteTo(packet, dst); err != nil {
+		utils.Log.Errorln("发送消息出错", err)
+		return failResult(err)
+	}
+	utils.Log.Traceln("success send tcp syn to", dst, time.Now().String())
+	time.Sleep(time.Duration(sendPkgInterval) * time.Millisecond)
+	return PingResult{Success: true}
+}
+
+// ParseTcpSynReply 解析收到的tcp报文，只认对SYN的回复：SYN-ACK或者带ACK的RST
+func ParseTcpSynReply(b []byte) (TcpSynReply, bool) {
+	if len(b) < 20 {
+		return TcpSynReply{}, false
+	}
+	flags := b[13]
+	if flags&tcpFlagAck == 0 {
+		return TcpSynReply{}, false
+	}
+	reply := TcpSynReply{
+		SrcPort: int(binary.BigEndian.Uint16(b[0:2])),
+		DstPort: int(binary.BigEndian.Uint16(b[2:4])),
+		Seq:     binary.BigEndian.Uint32(b[8:12]) - 1,
+	}
+	switch {
+	case flags&tcpFlagRst != 0:
+		reply.Open = false
+	case flags&tcpFlagSyn != 0:
+		reply.Open = true
+	default:
+		return TcpSynReply{}, false
+	}
+	return reply, true
+}
+
+// tcpSynSrcIp 计算校验和使用的源IP，指定了源IP直接使用，否则通过udp连接查询路由得到出口IP，不会发包
+func tcpSynSrcIp(dstIp net.IP, dstPort int, srcIp string) (net.IP, error) {
+	if srcIp != "" {
+		return net.ParseIP(srcIp), nil
+	}
+	if value, ok := tcpSynSrcIpCache.Load(dstIp.String()); ok {
+		return value.(net.IP), nil
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(dstIp.String(), strconv.Itoa(dstPort)))
+	if err != nil {
+		utils.Log.Errorln("查询出口IP出错", err)
+		return nil, err
+	}
+	defer conn.Close()
+	src := conn.LocalAddr().(*net.UDPAddr).IP
+	tcpSynSrcIpCache.Store(dstIp.String(), src)
+	return src, nil
+}
+
+// tcpChecksum 计算tcp校验和，包含ipv4或者ipv6的伪首部
+func tcpChecksum(src net.IP, dst net.IP, segment []byte) uint16 {
+	var pseudo []byte
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		pseudo = make([]byte, 12)
+		copy(pseudo[0:4], src4)
+		copy(pseudo[4:8], dst4)
+		pseudo[9] = 6
+		binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(segment)))
+	} else {
+		pseudo = make([]byte, 40)
+		copy(pseudo[0:16], src.To16())
+		copy(pseudo[16:32], dst.To16())
+		binary.BigEndian.PutUint32(pseudo[32:36], uint32(len(segment)))
+		pseudo[39] = 6
+	}
+	var sum uint32
+	for _, b := range [][]byte{pseudo, segment} {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
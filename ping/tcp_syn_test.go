@@ -0,0 +1,90 @@
+package ping
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestTcpChecksum(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		dst     string
+		segment []byte
+		want    uint16
+	}{
+		{"ipv4奇数长度", "192.0.2.1", "198.51.100.2", []byte{1, 2, 3}, 0x0fbd},
+		{"ipv4空报文", "192.0.2.1", "198.51.100.2", []byte{}, 0x13c2},
+		{"ipv6奇数长度", "2001:db8::1", "2001:db8::2", []byte{1, 2, 3}, 0xa07f},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tcpChecksum(net.ParseIP(tt.src), net.ParseIP(tt.dst), tt.segment); got != tt.want {
+				t.Errorf("tcpChecksum() = %#04x, want %#04x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenTcpSynPacket(t *testing.T) {
+	tests := []struct {
+		name         string
+		src          string
+		dst          string
+		wantChecksum uint16
+	}{
+		{"ipv4", "192.0.2.1", "198.51.100.2", 0x5d1d},
+		{"ipv6", "2001:db8::1", "2001:db8::2", 0xeddf},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, packet, err := GenTcpSynPacket(tt.dst, 443, tt.src, 20000, 0x01020304)
+			if err != nil {
+				t.Fatalf("GenTcpSynPacket() error = %v", err)
+			}
+			if got := binary.BigEndian.Uint16(packet[16:18]); got != tt.wantChecksum {
+				t.Errorf("checksum = %#04x, want %#04x", got, tt.wantChecksum)
+			}
+			// 带上校验和重新计算，结果为0说明校验和正确
+			if got := tcpChecksum(net.ParseIP(tt.src), net.ParseIP(tt.dst), packet); got != 0 {
+				t.Errorf("verify checksum = %#04x, want 0", got)
+			}
+		})
+	}
+}
+
+func TestParseTcpSynReply(t *testing.T) {
+	// 目标443回复源端口20000，确认号为序列号加1
+	segment := func(flags byte, ack uint32) []byte {
+		b := make([]byte, 20)
+		binary.BigEndian.PutUint16(b[0:2], 443)
+		binary.BigEndian.PutUint16(b[2:4], 20000)
+		binary.BigEndian.PutUint32(b[8:12], ack)
+		b[12] = 5 << 4
+		b[13] = flags
+		return b
+	}
+	tests := []struct {
+		name   string
+		b      []byte
+		want   TcpSynReply
+		wantOk bool
+	}{
+		{"SYN-ACK", segment(tcpFlagSyn|tcpFlagAck, 0x01020305), TcpSynReply{SrcPort: 443, DstPort: 20000, Seq: 0x01020304, Open: true}, true},
+		{"RST-ACK", segment(tcpFlagRst|tcpFlagAck, 0x01020305), TcpSynReply{SrcPort: 443, DstPort: 20000, Seq: 0x01020304, Open: false}, true},
+		{"序列号回绕", segment(tcpFlagSyn|tcpFlagAck, 0), TcpSynReply{SrcPort: 443, DstPort: 20000, Seq: 0xffffffff, Open: true}, true},
+		{"不带ACK的RST", segment(tcpFlagRst, 0), TcpSynReply{}, false},
+		{"只有ACK", segment(tcpFlagAck, 0x01020305), TcpSynReply{}, false},
+		{"SYN", segment(tcpFlagSyn, 0), TcpSynReply{}, false},
+		{"太短", segment(tcpFlagSyn|tcpFlagAck, 1)[:19], TcpSynReply{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseTcpSynReply(tt.b)
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("ParseTcpSynReply() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package ping
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+// NewTlsConfig 根据参数生成TLS配置，没有指定任何参数时返回nil，使用系统默认配置
+// skipVerify为true时不校验证书，caFile指定CA证书文件（PEM格式），sni指定握手时的服务器名称
+func NewTlsConfig(skipVerify bool, caFile string, sni string) (*tls.Config, error) {
+	if !skipVerify && caFile == "" && sni == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: skipVerify,
+		ServerName:         sni,
+	}
+	if caFile != "" {
+		content, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(content) {
+			return nil, errors.New("CA证书文件里没有有效的PEM证书")
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,139 @@
+package ping
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"go_ping/utils"
+	"net"
+	"strings"
+	"time"
+)
+
+// TlsResult tls打流握手的结果和服务端证书的信息
+type TlsResult struct {
+	Version    string    // 协商的TLS版本，比如TLS1.3
+	Cipher     string    // 协商的加密套件
+	Subject    string    // 叶子证书的CN
+	NotBefore  time.Time // 叶子证书的生效时间
+	NotAfter   time.Time // 叶子证书的过期时间
+	DaysLeft   int       // 叶子证书剩余的天数，已过期时为负数
+	Expiring   bool      // 叶子证书在告警天数内过期
+	NameMatch  bool      // 叶子证书的SAN和SNI或者目标IP是否匹配
+	ChainValid bool      // 证书链是否可信
+	ChainError string    // 证书链不可信的原因
+}
+
+// TlsPing tls ping原子函数，每个goroutines执行的
+// 建连后进行TLS握手，时延为握手时间，不包含tcp建连时间；
+// tlsConfig为nil时使用系统的CA证书校验，ServerName为空时域名目标使用域名作为SNI；
+// 证书过期算失败，证书链不可信、SAN不匹配在没有指定InsecureSkipVerify时算失败；
+// 证书在warnDays天内过期时只标记，不算失败，warnDays为0时不标记
+func TlsPing(dstIpOrDomain string, dstPort int, timeout int, srcIp string, tlsConfig *tls.Config, warnDays int) PingResult {
+	// 目标地址
+	dstAddress := net.JoinHostPort(dstIpOrDomain, fmt.Sprintf("%d", dstPort))
+	// 指定超时时间
+	if timeout <= 0 {
+		timeout = 1
+	}
+	duration := time.Duration(timeout) * time.Second
+	d := net.Dialer{Timeout: duration}
+	// 指定源IP
+	if srcIp != "" {
+		d.LocalAddr = &net.TCPAddr{IP: net.ParseIP(srcIp)}
+	}
+	conn, err := d.Dial("tcp", dstAddress)
+	if err != nil {
+		utils.Log.Traceln(err)
+		return failResult(err)
+	}
+	defer conn.Close()
+	// 握手时不校验证书，握手后自己校验，证书有问题时也能拿到证书信息
+	config := &tls.Config{}
+	skipVerify := false
+	if tlsConfig != nil {
+		config = tlsConfig.Clone()
+		skipVerify = tlsConfig.InsecureSkipVerify
+	}
+	if config.ServerName == "" && net.ParseIP(dstIpOrDomain) == nil {
+		config.ServerName = dstIpOrDomain
+	}
+	config.InsecureSkipVerify = true
+	tlsConn := tls.Client(conn, config)
+	if err = tlsConn.SetDeadline(time.Now().Add(duration)); err != nil {
+		utils.Log.Traceln(err)
+		return failResult(err)
+	}
+	startTime := time.Now()
+	err = tlsConn.Handshake()
+	rtt := time.Since(startTime)
+	if err != nil {
+		utils.Log.Traceln(err)
+		result := failResult(err)
+		// 握手被对端断开、协议不对等都算TLS失败
+		if result.Reason == FailReasonOther || result.Reason == FailReasonRefused {
+			result.Reason = FailReasonTls
+		}
+		if result.Reason == FailReasonTls {
+			result.Detail = err.Error()
+		}
+		return result
+	}
+	state := tlsConn.ConnectionState()
+	tlsResult := checkCertificate(state, config, dstIpOrDomain, warnDays)
+	result := PingResult{Success: true, Rtt: rtt, Tls: tlsResult}
+	switch {
+	case len(state.PeerCertificates) == 0:
+		result = PingResult{Reason: FailReasonCertChain, Detail: tlsResult.ChainError, Tls: tlsResult}
+	case time.Now().After(tlsResult.NotAfter):
+		result = PingResult{Reason: FailReasonCertExpired, Detail: fmt.Sprintf("证书已于%s过期", tlsResult.NotAfter.Format(time.RFC3339)), Tls: tlsResult}
+	case time.Now().Before(tlsResult.NotBefore):
+		result = PingResult{Reason: FailReasonCertExpired, Detail: fmt.Sprintf("证书要到%s才生效", tlsResult.NotBefore.Format(time.RFC3339)), Tls: tlsResult}
+	case !skipVerify && !tlsResult.ChainValid:
+		result = PingResult{Reason: FailReasonCertChain, Detail: tlsResult.ChainError, Tls: tlsResult}
+	case !skipVerify && !tlsResult.NameMatch:
+		result = PingResult{Reason: FailReasonCertName, Detail: fmt.Sprintf("证书不包含%s", tlsVerifyName(config, dstIpOrDomain)), Tls: tlsResult}
+	}
+	return result
+}
+
+// checkCertificate 校验服务端证书，返回证书信息
+func checkCertificate(state tls.ConnectionState, config *tls.Config, dstIpOrDomain string, warnDays int) *TlsResult {
+	tlsResult := &TlsResult{
+		Version: strings.ReplaceAll(tls.VersionName(state.Version), " ", ""),
+		Cipher:  tls.CipherSuiteName(state.CipherSuite),
+	}
+	if len(state.PeerCertificates) == 0 {
+		tlsResult.ChainError = "服务端没有证书"
+		return tlsResult
+	}
+	leaf := state.PeerCertificates[0]
+	tlsResult.Subject = leaf.Subject.CommonName
+	tlsResult.NotBefore = leaf.NotBefore
+	tlsResult.NotAfter = leaf.NotAfter
+	tlsResult.DaysLeft = int(time.Until(leaf.NotAfter).Hours() / 24)
+	tlsResult.Expiring = warnDays > 0 && time.Until(leaf.NotAfter) < time.Duration(warnDays)*24*time.Hour
+	tlsResult.NameMatch = leaf.VerifyHostname(tlsVerifyName(config, dstIpOrDomain)) == nil
+	// 只校验证书链，域名在上面单独校验
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         config.RootCAs,
+		Intermediates: intermediates,
+	})
+	tlsResult.ChainValid = err == nil
+	if err != nil {
+		tlsResult.ChainError = err.Error()
+	}
+	return tlsResult
+}
+
+// tlsVerifyName 校验SAN使用的名称，有SNI时使用SNI，否则使用目标
+func tlsVerifyName(config *tls.Config, dstIpOrDomain string) string {
+	if config.ServerName != "" {
+		return config.ServerName
+	}
+	return dstIpOrDomain
+}
@@ -0,0 +1,167 @@
+package ping
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go_ping/utils"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+	"time"
+)
+
+// TraceReply 逐跳探测收到的回复，中间路由器回复TTL超时，目标回复echo、SYN-ACK/RST或者udp端口不可达
+type TraceReply struct {
+	Key     string // 对应的探测，取值见TraceIcmpKey、TraceUdpKey、TraceTcpKey
+	Reached bool   // 是否是目标的回复，收到后不再探测更远的跳
+	Reason  string // 路由器返回目标不可达等差错时的原因，收到后不再探测更远的跳；TTL超时和到达目标时为空
+	Detail  string // 失败详情，带上返回差错报文的地址
+}
+
+// TraceIcmpKey icmp逐跳探测的key，由echo的ID和序号组成
+func TraceIcmpKey(id int, seq int) string {
+	return fmt.Sprintf("icmp|%d|%d", id, seq)
+}
+
+// TraceUdpKey udp逐跳探测的key，由源端口和目标端口组成，每个探测的目标端口不同
+func TraceUdpKey(srcPort int, dstPort int) string {
+	return fmt.Sprintf("udp|%d|%d", srcPort, dstPort)
+}
+
+// TraceTcpKey tcp逐跳探测的key，由源端口和SYN的序列号组成
+func TraceTcpKey(srcPort int, seq uint32) string {
+	return fmt.Sprintf("tcp|%d|%d", srcPort, seq)
+}
+
+// TraceIcmpSend 通过icmp打流的原始套接字发送TTL为ttl的echo请求
+func TraceIcmpSend(dst *net.IPAddr, handle net.PacketConn, handleV6 net.PacketConn, id int, seq int, ttl int) error {
+	conn := handle
+	var messageType icmp.Type = ipv4.ICMPTypeEcho
+	if dst.IP.To4() == nil {
+		conn = handleV6
+		messageType = ipv6.ICMPTypeEchoRequest
+	}
+	ipConn, ok := conn.(*net.IPConn)
+	if !ok {
+		return errors.New("逐跳探测需要使用原始套接字")
+	}
+	message := icmp.Message{
+		Type: messageType,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   id,
+			Seq:  seq,
+			Data: genIcmpPayload(time.Now(), id, 0, nil),
+		},
+	}
+	binaryMessage, err := message.Marshal(nil)
+	if err != nil {
+		utils.Log.Errorln("将ICMP消息编码为字节出错", err)
+		return err
+	}
+	if err = writeWithTtl(ipConn, binaryMessage, dst, ttl); err != nil {
+		utils.Log.Errorln("发送消息出错", err)
+		return err
+	}
+	utils.Log.Traceln("success send trace icmp to", dst, "ttl", ttl)
+	return nil
+}
+
+// TraceTcpSend 通过tcp-syn打流的原始套接字发送TTL为ttl的SYN，packet由GenTcpSynPacket生成
+func TraceTcpSend(dst *net.IPAddr, packet []byte, handle *net.IPConn, handleV6 *net.IPConn, ttl int) error {
+	conn := handle
+	if dst.IP.To4() == nil {
+		conn = handleV6
+	}
+	if err := writeWithTtl(conn, packet, dst, ttl); err != nil {
+		utils.Log.Errorln("发送消息出错", err)
+		return err
+	}
+	utils.Log.Traceln("success send trace tcp syn to", dst, "ttl", ttl)
+	return nil
+}
+
+// TraceUdpSend 设置udp连接的TTL后发包到目标的dstPort，同一个连接不能并发调用
+func TraceUdpSend(conn *net.UDPConn, dst *net.IPAddr, dstPort int, ttl int) error {
+	var err error
+	if dst.IP.To4() == nil {
+		err = ipv6.NewConn(conn).SetHopLimit(ttl)
+	} else {
+		err = ipv4.NewConn(conn).SetTTL(ttl)
+	}
+	if err != nil {
+		utils.Log.Errorln("设置TTL出错", err)
+		return err
+	}
+	if _, err = conn.WriteTo([]byte(utils.DefaultUdpPayload), &net.UDPAddr{IP: dst.IP, Port: dstPort, Zone: dst.Zone}); err != nil {
+		utils.Log.Errorln("发送消息出错", err)
+		return err
+	}
+	utils.Log.Traceln("success send trace udp to", dst, dstPort, "ttl", ttl)
+	return nil
+}
+
+// ParseTraceIcmp 解析icmp原始套接字收到的报文：echo回复，以及引用了echo请求、udp或者tcp报文的差错报文
+// b为不包含ip头的icmp报文，router为回复的地址
+func ParseTraceIcmp(b []byte, v6 bool, router string) (TraceReply, bool) {
+	if len(b) < 8 {
+		return TraceReply{}, false
+	}
+	// 目标的echo回复，只认本进程发出的
+	if (v6 && b[0] == icmpV6TypeEchoReply) || (!v6 && b[0] == icmpV4TypeEchoReply) {
+		if payload, ok := ParseIcmpPayload(b[8:]); !ok || payload.Foreign {
+			return TraceReply{}, false
+		}
+		key := TraceIcmpKey(int(binary.BigEndian.Uint16(b[4:6])), int(binary.BigEndian.Uint16(b[6:8])))
+		return TraceReply{Key: key, Reached: true}, true
+	}
+	var result PingResult
+	var ok bool
+	if v6 {
+		result, ok = icmpV6ErrorResult(b)
+	} else {
+		result, ok = icmpV4ErrorResult(b)
+	}
+	if !ok {
+		return TraceReply{}, false
+	}
+	proto, transport, ok := quotedTransport(b[8:], v6)
+	if !ok {
+		return TraceReply{}, false
+	}
+	reply := TraceReply{}
+	switch proto {
+	case protoIcmp, protoIcmpV6:
+		if echo, ok := quotedEcho(b[8:], v6); ok {
+			reply.Key = TraceIcmpKey(int(binary.BigEndian.Uint16(echo[4:6])), int(binary.BigEndian.Uint16(echo[6:8])))
+		}
+	case protoUdp:
+		reply.Key = TraceUdpKey(int(binary.BigEndian.Uint16(transport[0:2])), int(binary.BigEndian.Uint16(transport[2:4])))
+	case protoTcp:
+		reply.Key = TraceTcpKey(int(binary.BigEndian.Uint16(transport[0:2])), binary.BigEndian.Uint32(transport[4:8]))
+	}
+	if reply.Key == "" {
+		return TraceReply{}, false
+	}
+	// udp探测的是目标上没有监听的高端口，目标回复端口不可达表示到达
+	portUnreachable := (v6 && b[0] == icmpV6TypeDstUnreach && b[1] == 4) || (!v6 && b[0] == icmpV4TypeDstUnreach && b[1] == 3)
+	switch {
+	case proto == protoUdp && portUnreachable:
+		reply.Reached = true
+	case result.Reason != FailReasonTtlExceeded:
+		reply.Reason = result.Reason
+		reply.Detail = fmt.Sprintf("来自%s：%s", router, result.Detail)
+	}
+	return reply, true
+}
+
+// ParseTraceTcp 解析tcp原始套接字收到的报文，目标回复SYN-ACK或者RST表示到达
+func ParseTraceTcp(b []byte) (TraceReply, bool) {
+	synReply, ok := ParseTcpSynReply(b)
+	if !ok {
+		return TraceReply{}, false
+	}
+	return TraceReply{Key: TraceTcpKey(synReply.DstPort, synReply.Seq), Reached: true}, true
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package ping
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// writeWithTtl 通过控制消息指定这一个包的TTL（ipv6为hop limit）发包，多个目标并发逐跳探测时共用同一个原始套接字
+func writeWithTtl(conn *net.IPConn, b []byte, dst *net.IPAddr, ttl int) error {
+	level, typ := syscall.IPPROTO_IP, syscall.IP_TTL
+	if dst.IP.To4() == nil {
+		level, typ = syscall.IPPROTO_IPV6, syscall.IPV6_HOPLIMIT
+	}
+	oob := make([]byte, syscall.CmsgSpace(4))
+	header := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	header.Level = int32(level)
+	header.Type = int32(typ)
+	header.SetLen(syscall.CmsgLen(4))
+	*(*int32)(unsafe.Pointer(&oob[syscall.CmsgLen(0)])) = int32(ttl)
+	_, _, err := conn.WriteMsgIP(b, oob, dst)
+	return err
+}
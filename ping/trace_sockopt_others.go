@@ -0,0 +1,16 @@
+//go:build !linux
+
+package ping
+
+import (
+	"errors"
+	"net"
+)
+
+// errTraceUnsupported 其他系统不支持按包指定TTL
+var errTraceUnsupported = errors.New("当前系统不支持逐跳探测")
+
+// writeWithTtl 其他系统不支持按包指定TTL
+func writeWithTtl(conn *net.IPConn, b []byte, dst *net.IPAddr, ttl int) error {
+	return errTraceUnsupported
+}
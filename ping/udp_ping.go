@@ -0,0 +1,72 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"go_ping/utils"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// UdpPing udp ping原子函数，每个goroutines执行的
+// 收到udp回复表示端口开放，收到icmp端口不可达表示端口关闭，超时没有任何回复表示被过滤或者服务不回复
+// silenceSuccess为true时，没有任何回复也算成功，适用于syslog这种不回复的服务，结果的Silent为true，没有时延
+func UdpPing(dstIpOrDomain string, dstPort int, timeout int, srcIp string, payload []byte, silenceSuccess bool) PingResult {
+	// 目标地址
+	dstAddress := net.JoinHostPort(dstIpOrDomain, fmt.Sprintf("%d", dstPort))
+	// 指定超时时间
+	if timeout <= 0 {
+		timeout = 1
+	}
+	duration := time.Duration(timeout) * time.Second
+	d := net.Dialer{Timeout: duration}
+	// 指定源IP
+	if srcIp != "" {
+		srcAddress := fmt.Sprintf("%s:0", srcIp)
+		if strings.Contains(srcIp, ":") {
+			srcAddress = fmt.Sprintf("[%s]:0", srcIp)
+		}
+		srcUDPAddress, err := net.ResolveUDPAddr("udp", srcAddress)
+		if err != nil {
+			utils.Log.Errorln(err)
+			return failResult(err)
+		}
+		d = net.Dialer{
+			LocalAddr: srcUDPAddress,
+			Timeout:   duration,
+		}
+	}
+	// udp的Dial只是绑定目的地址，这样内核收到icmp端口不可达时，读操作会返回连接被拒绝
+	conn, err := d.Dial("udp", dstAddress)
+	if err != nil {
+		utils.Log.Traceln(err)
+		return failResult(err)
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(duration))
+	if err != nil {
+		utils.Log.Traceln(err)
+		return failResult(err)
+	}
+	startTime := time.Now()
+	if _, err = conn.Write(payload); err != nil {
+		utils.Log.Traceln(err)
+		return failResult(err)
+	}
+	reply := make([]byte, 1500)
+	_, err = conn.Read(reply)
+	if err != nil {
+		utils.Log.Traceln(err)
+		// 超时没有任何回复
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if silenceSuccess {
+				return PingResult{Success: true, Silent: true}
+			}
+			return PingResult{Reason: FailReasonFiltered}
+		}
+		return failResult(err)
+	}
+	return PingResult{Success: true, Rtt: time.Since(startTime)}
+}
@@ -5,6 +5,7 @@ package task
 import (
 	"fmt"
 	mapset "github.com/deckarep/golang-set"
+	"go_ping/ping"
 	"go_ping/utils"
 	"sort"
 	"strconv"
@@ -27,17 +28,21 @@ type RoutineTaskItem struct {
 
 // TaskItem 一个最细粒度的任务，直接用来调度的
 type TaskItem struct {
-	Id               int       // 任务id
-	DstTarget        string    // 探测目表
-	DstPort          int       // 探测目标端口
-	SrcIp            string    // 源ip，可为空，如果客户没有指定的话
-	Timeout          int       // 超时时间，有默认值
-	PingType         string    // tcp/udp/icmp/http
-	IcmpId           int       // icmp需要以下字段,请求ID
-	IcmpSeq          int       // 序列号
-	IcmpSendInterval int       // icmp每个goroutine的发包间隔，单位毫秒
-	SendTime         time.Time // 发送时间
-	Completed        bool      // 是否已完成
+	Id               int              // 任务id
+	DstTarget        string           // 探测目表
+	DstPort          int              // 探测目标端口
+	SrcIp            string           // 源ip，可为空，如果客户没有指定的话
+	Timeout          int              // 超时时间，有默认值
+	PingType         string           // tcp/udp/icmp/http
+	IcmpId           int              // icmp需要以下字段,请求ID
+	IcmpSeq          int              // 序列号
+	IcmpSendInterval int              // icmp、tcp-syn每个goroutine的发包间隔，单位毫秒
+	TcpSynSrcPort    int              // tcp-syn需要以下字段，发SYN的源端口
+	TcpSynSeq        uint32           // 发SYN的序列号，随机生成，持续打流时每一轮重新生成
+	SendTime         time.Time        // 发送时间
+	Completed        bool             // 是否已完成
+	HttpOption       *ping.HttpOption // http打流的选项，多个任务共用，只读
+	HttpKey          string           // http打流统计的key，为url，文件的行指定了选项时再加上排序后的选项
 }
 
 // =============================================
@@ -47,15 +52,50 @@ type FailRate struct {
 	mutex             sync.Mutex               //锁住以下字段
 	SuccessNumber     int                      // 成功数
 	FailNumber        int                      // 失败数
+	LocalFailNumber   int                      // 本机资源耗尽导致的失败数，不是目标的问题
+	LateNumber        int                      // icmp、tcp-syn打流超时以后才到的回复数，按超时计算，不计入成功数
+	DupNumber         int                      // icmp打流同一个请求收到多个回复时，多出来的回复数
+	ReorderNumber     int                      // icmp打流比同一个目标后发的请求更晚收到的回复数
+	ForeignNumber     int                      // icmp打流收到的不是本进程发出的回复数，比如系统的ping、其他go_ping
 	ResultMap         map[string]*FailRateItem // 放每个IP的统计
 	LastResultMap     map[string]*FailRateItem // 上一次的统计，用于跟本次对比
 	FromSuccessToFail mapset.Set               // 输出变化的IP
 	FromFailToSuccess mapset.Set               // 输出变化的IP
+	HopMap            map[string]int           // icmp、tcp-syn打流每个目标确认的跳数，不随每轮清空，连续多轮没有估算时清除
+	HopChangeList     []HopChange              // 本轮跳数发生变化的目标，一般是路由变了
+	hopCandidateMap   map[string]*hopCandidate // 估算到和确认的跳数不同、还没有达到确认次数的新跳数
+	hopIdleMap        map[string]int           // 每个目标连续没有估算跳数的轮数
+	hopEventList      []HopChange              // 还没有输出ndjson事件的跳数变化
+	rttAgg            RttAgg                   // 所有目标的时延的累计统计
+	httpTimingAgg     HttpTimingAgg            // 所有目标http打流各阶段耗时的累计值
+}
+
+// HopChange 一个目标的跳数变化
+type HopChange struct {
+	Target string // 目标，与ResultMap的key一致
+	From   int    // 变化前的跳数
+	To     int    // 变化后的跳数
+}
+
+// hopCandidate 还没有确认的新跳数
+type hopCandidate struct {
+	Hops   int // 新跳数
+	Number int // 连续估算到新跳数的次数
 }
 
 type FailRateItem struct {
-	SuccessNumber int // 成功数
-	FailNumber    int // 失败数
+	SuccessNumber int             // 成功数
+	FailNumber    int             // 失败数
+	RttAgg        RttAgg          // 成功探测的时延的累计统计
+	FailReasonMap map[string]int  // 每种失败原因的次数
+	DnsRcodeMap   map[string]int  // dns打流每种应答码的次数
+	DnsAnswerMap  map[string]int  // dns打流每种应答记录集合的次数，记录之间用逗号分隔
+	HttpTimingAgg HttpTimingAgg   // http打流成功探测各阶段耗时的累计值
+	RedirectMap   map[string]int  // http打流每种跳转链的次数，url之间用" -> "连接
+	Tls           *ping.TlsResult // tls打流最近一次握手的证书信息
+	DupNumber     int             // icmp打流重复的回复数，一般是环路或者链路上的设备复制了包
+	ReorderNumber int             // icmp打流乱序的回复数，一般是ECMP的多条路径时延不同
+	LateNumber    int             // icmp、tcp-syn打流超时以后才到的回复数
 }
 
 // NewFailRate 初始化一个空FailRate
@@ -65,43 +105,245 @@ func NewFailRate() *FailRate {
 		LastResultMap:     make(map[string]*FailRateItem),
 		FromSuccessToFail: mapset.NewSet(),
 		FromFailToSuccess: mapset.NewSet(),
+		HopMap:            make(map[string]int),
+		hopCandidateMap:   make(map[string]*hopCandidate),
+		hopIdleMap:        make(map[string]int),
 	}
 }
 
-func (c *FailRate) Increment(key string, success bool) (successNum int, failNum int) {
+func (c *FailRate) Increment(key string, result ping.PingResult) (successNum int, failNum int) {
 	c.mutex.Lock()
-	if success {
+	if result.Success {
 		c.SuccessNumber++
 	} else {
 		c.FailNumber++
+		if result.Reason == ping.FailReasonLocal {
+			c.LocalFailNumber++
+		}
 	}
 	s := c.SuccessNumber
 	f := c.FailNumber
-	// 检查key是否存在
-	value, exists := c.ResultMap[key]
-	if exists {
-		if success {
-			value.SuccessNumber++
-		} else {
-			value.FailNumber++
+	value := c.resultItem(key)
+	if result.Ttl > 0 {
+		c.updateHops(key, ping.EstimateHops(result.Ttl))
+	}
+	if result.Dns != nil {
+		value.DnsRcodeMap[result.Dns.Rcode]++
+		value.DnsAnswerMap[strings.Join(result.Dns.Answers, ",")]++
+	}
+	if result.Tls != nil {
+		value.Tls = result.Tls
+	}
+	if len(result.Redirects) > 0 {
+		value.RedirectMap[RedirectChainString(key, result.Redirects)]++
+	}
+	if result.Success {
+		value.SuccessNumber++
+		// 没有回复的udp成功没有时延，不计入时延统计
+		if !result.Silent {
+			value.RttAgg.Add(result.Rtt)
+			c.rttAgg.Add(result.Rtt)
 		}
-	} else {
-		if success {
-			c.ResultMap[key] = &FailRateItem{
-				SuccessNumber: 1,
-				FailNumber:    0,
-			}
-		} else {
-			c.ResultMap[key] = &FailRateItem{
-				SuccessNumber: 0,
-				FailNumber:    1,
-			}
+		if result.Http != nil {
+			value.HttpTimingAgg.Add(*result.Http)
+			c.httpTimingAgg.Add(*result.Http)
 		}
+	} else {
+		value.FailNumber++
+		value.FailReasonMap[result.Reason]++
 	}
 	c.mutex.Unlock()
 	return s, f
 }
 
+// resultItem 取出key的统计，不存在时新建，调用方需要加锁
+func (c *FailRate) resultItem(key string) *FailRateItem {
+	value, exists := c.ResultMap[key]
+	if !exists {
+		value = &FailRateItem{
+			FailReasonMap: make(map[string]int),
+			DnsRcodeMap:   make(map[string]int),
+			DnsAnswerMap:  make(map[string]int),
+			RedirectMap:   make(map[string]int),
+		}
+		c.ResultMap[key] = value
+	}
+	return value
+}
+
+// updateHops 更新目标的跳数，连续HopChangeConfirmNum次估算到相同的新跳数时才记录跳数变化，调用方需要加锁
+func (c *FailRate) updateHops(key string, hops int) {
+	if hops < 0 {
+		return
+	}
+	c.hopIdleMap[key] = 0
+	lastHops, exists := c.HopMap[key]
+	if !exists {
+		c.HopMap[key] = hops
+		return
+	}
+	if lastHops == hops {
+		delete(c.hopCandidateMap, key)
+		return
+	}
+	candidate, pending := c.hopCandidateMap[key]
+	if !pending || candidate.Hops != hops {
+		candidate = &hopCandidate{Hops: hops}
+		c.hopCandidateMap[key] = candidate
+	}
+	candidate.Number++
+	if candidate.Number < utils.HopChangeConfirmNum {
+		return
+	}
+	delete(c.hopCandidateMap, key)
+	hopChange := HopChange{Target: key, From: lastHops, To: hops}
+	c.HopChangeList = append(c.HopChangeList, hopChange)
+	c.hopEventList = append(c.hopEventList, hopChange)
+	utils.Log.Warnln("hop count changed:", key, lastHops, "->", hops)
+	c.HopMap[key] = hops
+}
+
+// TakeHopChanges 取出还没有输出ndjson事件的跳数变化
+func (c *FailRate) TakeHopChanges() []HopChange {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	hopChangeList := c.hopEventList
+	c.hopEventList = nil
+	return hopChangeList
+}
+
+// pruneHops 清除连续HopExpireRounds轮没有估算跳数的目标，调用方需要加锁
+func (c *FailRate) pruneHops() {
+	for key := range c.hopIdleMap {
+		c.hopIdleMap[key]++
+		if c.hopIdleMap[key] > utils.HopExpireRounds {
+			delete(c.HopMap, key)
+			delete(c.hopCandidateMap, key)
+			delete(c.hopIdleMap, key)
+		}
+	}
+}
+
+// IncrementLate 记录一次超时以后才到的回复，key为空表示不知道是哪个目标的
+func (c *FailRate) IncrementLate(key string) {
+	c.mutex.Lock()
+	c.LateNumber++
+	if key != "" {
+		c.resultItem(key).LateNumber++
+	}
+	c.mutex.Unlock()
+}
+
+// IncrementDup 记录一次重复的回复
+func (c *FailRate) IncrementDup(key string) {
+	c.mutex.Lock()
+	c.DupNumber++
+	c.resultItem(key).DupNumber++
+	c.mutex.Unlock()
+}
+
+// IncrementReorder 记录一次乱序的回复
+func (c *FailRate) IncrementReorder(key string) {
+	c.mutex.Lock()
+	c.ReorderNumber++
+	c.resultItem(key).ReorderNumber++
+	c.mutex.Unlock()
+}
+
+// IncrementForeign 记录一次不是本进程发出的回复
+func (c *FailRate) IncrementForeign() {
+	c.mutex.Lock()
+	c.ForeignNumber++
+	c.mutex.Unlock()
+}
+
+// rttStat 统计所有目标的时延，调用方需要加锁
+func (c *FailRate) rttStat() RttStat {
+	return c.rttAgg.Stat()
+}
+
+// httpTimingStat 统计所有目标http打流各阶段的耗时，调用方需要加锁
+func (c *FailRate) httpTimingStat() HttpTimingStat {
+	return c.httpTimingAgg.Stat()
+}
+
+// failReasonMap 汇总所有目标的失败原因，调用方需要加锁
+func (c *FailRate) failReasonMap() map[string]int {
+	reasonMap := make(map[string]int)
+	for _, failRateItem := range c.ResultMap {
+		for reason, number := range failRateItem.FailReasonMap {
+			reasonMap[reason] += number
+		}
+	}
+	return reasonMap
+}
+
+// FailReasonString 展示用，按失败原因排序，比如timeout:3,refused:1；没有失败时展示-
+func FailReasonString(reasonMap map[string]int) string {
+	reasons := make([]string, 0, len(reasonMap))
+	for reason, number := range reasonMap {
+		if number > 0 {
+			reasons = append(reasons, reason)
+		}
+	}
+	if len(reasons) == 0 {
+		return "-"
+	}
+	sort.Strings(reasons)
+	for i, reason := range reasons {
+		reasons[i] = fmt.Sprintf("%s:%d", reason, reasonMap[reason])
+	}
+	return strings.Join(reasons, ",")
+}
+
+// LocalFailHint 有本机资源耗尽导致的失败时，提示用户不是目标的问题，没有时返回空
+func LocalFailHint(localFailNumber int) string {
+	if localFailNumber == 0 {
+		return ""
+	}
+	return fmt.Sprintf("有%d次失败是本机资源耗尽（源端口、文件句柄、缓冲区不够）导致的，不代表目标异常，可以降低并发，tcp打流可以使用--tcp.close.rst避免TIME_WAIT占用源端口", localFailNumber)
+}
+
+// LateForeignHint 有迟到的回复或者其他进程的回复时，提示用户这些回复没有计入成功数，没有时返回空
+func LateForeignHint(lateNumber int, foreignNumber int) string {
+	if lateNumber == 0 && foreignNumber == 0 {
+		return ""
+	}
+	return fmt.Sprintf("有%d个回复超时以后才到，按超时计算；丢弃了%d个其他进程（系统ping、其他go_ping）的回复", lateNumber, foreignNumber)
+}
+
+// ReplyAnomalyString 展示用，icmp打流的重复/乱序/迟到回复数，比如0/2/1
+func ReplyAnomalyString(dupNumber int, reorderNumber int, lateNumber int) string {
+	return fmt.Sprintf("%d/%d/%d", dupNumber, reorderNumber, lateNumber)
+}
+
+// HopsString 展示用，目标的跳数，没有估算过时为-
+func HopsString(hopMap map[string]int, key string) string {
+	hops, exists := hopMap[key]
+	if !exists {
+		return "-"
+	}
+	return strconv.Itoa(hops)
+}
+
+// HopChangeString 展示用，跳数变化，比如1.1.1.1:10->12,...，只展示第一个，没有变化时为空
+func HopChangeString(hopChangeList []HopChange) string {
+	if len(hopChangeList) == 0 {
+		return ""
+	}
+	hopChange := hopChangeList[0]
+	hasMore := ""
+	if len(hopChangeList) > 1 {
+		hasMore = ",..."
+	}
+	return fmt.Sprintf("%s:%d->%d%s", hopChange.Target, hopChange.From, hopChange.To, hasMore)
+}
+
+// RedirectChainString 展示用，跳转链，比如http://a.com -> https://a.com/ -> https://a.com/login
+func RedirectChainString(url string, redirects []string) string {
+	return strings.Join(append([]string{url}, redirects...), " -> ")
+}
+
 func (c *FailRate) Statistic() {
 	c.mutex.Lock()
 	// 和上次比较失败的、成功的
@@ -150,6 +392,15 @@ func (c *FailRate) Clean() {
 	c.mutex.Lock()
 	c.SuccessNumber = 0
 	c.FailNumber = 0
+	c.LocalFailNumber = 0
+	c.LateNumber = 0
+	c.ForeignNumber = 0
+	c.DupNumber = 0
+	c.ReorderNumber = 0
+	c.HopChangeList = nil
+	c.rttAgg = RttAgg{}
+	c.httpTimingAgg = HttpTimingAgg{}
+	c.pruneHops()
 	for _, failRateItem := range c.LastResultMap {
 		// 清空老的
 		failRateItem.SuccessNumber = 0
@@ -170,6 +421,16 @@ func (c *FailRate) Clean() {
 		// 清零
 		failRateItem.SuccessNumber = 0
 		failRateItem.FailNumber = 0
+		failRateItem.RttAgg = RttAgg{}
+		failRateItem.HttpTimingAgg = HttpTimingAgg{}
+		failRateItem.FailReasonMap = make(map[string]int)
+		failRateItem.DnsRcodeMap = make(map[string]int)
+		failRateItem.DnsAnswerMap = make(map[string]int)
+		failRateItem.RedirectMap = make(map[string]int)
+		failRateItem.Tls = nil
+		failRateItem.DupNumber = 0
+		failRateItem.ReorderNumber = 0
+		failRateItem.LateNumber = 0
 	}
 	c.mutex.Unlock()
 }
@@ -178,18 +439,58 @@ func (c *FailRate) Clean() {
 
 // ParamInput 存储用户命令行输入的参数
 type ParamInput struct {
-	DstTarget    string
-	DstPort      int
-	DstFile      string
-	DstFileLoose bool
-	SrcIp        string
-	PingType     string
-	Timeout      int
-	Concurrency  int
-	Number       int
-	ShowMode     string
-	LogLevel     string
-	DomainA      bool
+	DstTarget           string `json:"dst_target"`
+	DstPort             int    `json:"dst_port"`
+	DstFile             string `json:"dst_file"`
+	DstFileLoose        bool   `json:"dst_file_loose"`
+	SrcIp               string `json:"src_ip"`
+	PingType            string `json:"ping_type"`
+	Timeout             int    `json:"timeout"`
+	Concurrency         int    `json:"concurrency"`
+	Number              int    `json:"number"`
+	ShowMode            string `json:"show_mode"`
+	LogLevel            string `json:"log_level"`
+	DomainA             bool   `json:"domain_a"`
+	ShowTop             int    `json:"show_top"`
+	TcpSend             string `json:"tcp_send"`
+	TcpExpect           string `json:"tcp_expect"`
+	TcpExpectRegex      string `json:"tcp_expect_regex"`
+	TcpReadTimeout      int    `json:"tcp_read_timeout"`
+	TcpCloseRst         bool   `json:"tcp_close_rst"`
+	UdpPayload          string `json:"udp_payload"`
+	UdpSilenceOk        bool   `json:"udp_silence_ok"`
+	DnsName             string `json:"dns_name"`
+	DnsType             string `json:"dns_type"`
+	DnsProto            string `json:"dns_proto"`
+	DnsExpect           string `json:"dns_expect"`
+	HttpScheme          string `json:"http_scheme"`
+	TlsSkipVerify       bool   `json:"tls_skip_verify"`
+	TlsWarnDays         int    `json:"tls_warn_days"`
+	TlsCaFile           string `json:"tls_ca_file"`
+	TlsSni              string `json:"tls_sni"`
+	HttpMethod          string `json:"http_method"`
+	HttpRequestHeader   string `json:"-"` // 可能包含密钥，不在json结果里输出
+	HttpRequestBodyFile string `json:"http_request_body_file"`
+	HttpHost            string `json:"http_host"`
+	HttpRedirect        string `json:"http_redirect"`
+	HttpRedirectMax     int    `json:"http_redirect_max"`
+	HttpConn            string `json:"http_conn"`
+	HttpStatus          string `json:"http_status"`
+	HttpBody            string `json:"http_body"`
+	HttpBodyRegex       string `json:"http_body_regex"`
+	HttpHeader          string `json:"http_header"`
+	HttpBodyMax         string `json:"http_body_max"`
+	IcmpSize            int    `json:"icmp_size"`
+	IcmpPattern         string `json:"icmp_pattern"`
+	IcmpTtl             int    `json:"icmp_ttl"`
+	IcmpDf              bool   `json:"icmp_df"`
+	IcmpTos             int    `json:"icmp_tos"`
+	IcmpSock            string `json:"icmp_sock"`
+	TraceProto          string `json:"trace_proto"`
+	TraceMaxHops        int    `json:"trace_max_hops"`
+	TraceQueries        int    `json:"trace_queries"`
+	// icmp连接上实际生效的发包选项，创建连接后从内核读回，json结果里单独输出
+	IcmpEffective *ping.IcmpEffectiveOption `json:"-"`
 }
 
 // ==================================================
@@ -208,6 +509,12 @@ type ForeverTableLine struct {
 	FailNumber     string
 	TotalNumber    string
 	FailPercent    string
+	Rtt            string // 时延min/avg/max/mdev
+	RttPercentile  string // 时延p50/p90/p99
+	HttpTiming     string // http打流各阶段的平均耗时
+	ReplyAnomaly   string // icmp打流重复/乱序/迟到的回复数
+	HopChange      string // icmp、tcp-syn打流跳数发生变化的目标
+	FailReason     string // 失败原因
 	ChangeIpNumber string // 连通性变化的IP个数
 	ChangeIpSet    string // 变化的IP
 }
@@ -217,7 +524,7 @@ func NewForeverTable() *ForeverTable {
 	return &ForeverTable{ForeverTableList: []ForeverTableLine{}}
 }
 
-func (c *ForeverTable) AppendLine(successNum int, failNum int, FromSuccessToFail mapset.Set, FromFailToSuccess mapset.Set) {
+func (c *ForeverTable) AppendLine(successNum int, failNum int, rttStat RttStat, httpTimingStat HttpTimingStat, replyAnomaly string, hopChange string, failReasonMap map[string]int, FromSuccessToFail mapset.Set, FromFailToSuccess mapset.Set) {
 	showIpLen := 1
 	ChangeIPSet := FromSuccessToFail.Union(FromFailToSuccess)
 	slice := ChangeIPSet.ToSlice()
@@ -259,6 +566,12 @@ func (c *ForeverTable) AppendLine(successNum int, failNum int, FromSuccessToFail
 		FailNumber:     strconv.Itoa(failNum),
 		TotalNumber:    strconv.Itoa(totalNum),
 		FailPercent:    fmt.Sprintf("%.2f%%", failPercent),
+		Rtt:            rttStat.MinAvgMaxMdev(),
+		RttPercentile:  rttStat.Percentiles(),
+		HttpTiming:     httpTimingStat.String(),
+		ReplyAnomaly:   replyAnomaly,
+		HopChange:      hopChange,
+		FailReason:     FailReasonString(failReasonMap),
 		ChangeIpNumber: strconv.Itoa(ChangeIPSet.Cardinality()),
 		ChangeIpSet:    strings.Join(changeIpList, ",") + hasMore,
 	}
@@ -281,5 +594,6 @@ type FileTaskItemNumber struct {
 	//TotalLineNumber int    // 文件总行数
 	//ValidLineNumber int    // 有效行数
 	//TaskLineNumber  int    // 构成任务的行数（比如用户指定有些探测不通的目标，不形成任务）
-	TaskNumber int // 构成的任务数，比如网段：一行就可以构成很多任务，目前就使用了这个字段
+	TaskNumber int    // 构成的任务数，比如网段：一行就可以构成很多任务
+	TaskId     string // 大任务id，json输出时使用
 }
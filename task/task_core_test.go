@@ -0,0 +1,65 @@
+package task
+
+import (
+	"go_ping/utils"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateHops(t *testing.T) {
+	tests := []struct {
+		name           string
+		hopsList       []int
+		wantHops       int
+		wantChangeList []HopChange
+	}{
+		{"第一次估算", []int{10}, 10, nil},
+		{"跳数不变", []int{10, 10, 10}, 10, nil},
+		{"只变了1次不算", []int{10, 12}, 10, nil},
+		{"偶尔走了别的路径", []int{10, 12, 10, 12, 10}, 10, nil},
+		{"连续2次相同的新跳数", []int{10, 12, 12}, 12, []HopChange{{Target: "1.1.1.1", From: 10, To: 12}}},
+		{"连续2次不同的新跳数不算", []int{10, 12, 13}, 10, nil},
+		{"变化后再变回来", []int{10, 12, 12, 10, 10}, 10, []HopChange{{Target: "1.1.1.1", From: 10, To: 12}, {Target: "1.1.1.1", From: 12, To: 10}}},
+		{"读不到TTL不影响", []int{10, 12, -1, 12}, 12, []HopChange{{Target: "1.1.1.1", From: 10, To: 12}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fr := NewFailRate()
+			for _, hops := range tt.hopsList {
+				fr.updateHops("1.1.1.1", hops)
+			}
+			if got := fr.HopMap["1.1.1.1"]; got != tt.wantHops {
+				t.Errorf("HopMap = %d, want %d", got, tt.wantHops)
+			}
+			if !reflect.DeepEqual(fr.HopChangeList, tt.wantChangeList) {
+				t.Errorf("HopChangeList = %v, want %v", fr.HopChangeList, tt.wantChangeList)
+			}
+			if got := fr.TakeHopChanges(); !reflect.DeepEqual(got, tt.wantChangeList) {
+				t.Errorf("TakeHopChanges() = %v, want %v", got, tt.wantChangeList)
+			}
+			if got := fr.TakeHopChanges(); got != nil {
+				t.Errorf("TakeHopChanges() again = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestPruneHops(t *testing.T) {
+	fr := NewFailRate()
+	fr.updateHops("1.1.1.1", 10)
+	fr.updateHops("2.2.2.2", 10)
+	for i := 0; i < utils.HopExpireRounds; i++ {
+		fr.updateHops("2.2.2.2", 10)
+		fr.Clean()
+	}
+	if _, exists := fr.HopMap["1.1.1.1"]; !exists {
+		t.Fatalf("pruned after %d rounds, want kept", utils.HopExpireRounds)
+	}
+	fr.Clean()
+	if _, exists := fr.HopMap["1.1.1.1"]; exists {
+		t.Errorf("kept after %d rounds, want pruned", utils.HopExpireRounds+1)
+	}
+	if _, exists := fr.HopMap["2.2.2.2"]; !exists {
+		t.Errorf("pruned a target that still replies")
+	}
+}
@@ -0,0 +1,146 @@
+// Package task 本包提供ndjson格式的探测事件输出，每个探测结果输出一行json，适用于流式对接jq、日志采集等
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"go_ping/ping"
+	"go_ping/utils"
+	"sync"
+	"time"
+)
+
+var (
+	ProbeOutcomeSuccess   = "success"
+	ProbeOutcomeFail      = "fail"
+	PortStateOpenFiltered = "open|filtered" // udp没有任何回复，端口可能开放也可能被过滤
+	EventHopChange        = "hop_change"    // 跳数变化事件
+)
+
+// eventMutex 多个goroutine同时输出时，保证每行json完整
+var eventMutex sync.Mutex
+
+// ProbeEvent 一个探测结果事件
+type ProbeEvent struct {
+	Time       string          `json:"time"`                  // 事件时间，RFC3339Nano格式
+	TaskId     string          `json:"task_id"`               // 大任务id
+	RoutineId  int             `json:"routine_id"`            // 协程id
+	BatchId    int             `json:"batch_id"`              // 批次，即TaskItem.Id
+	Target     string          `json:"target"`                // 探测目标
+	Port       int             `json:"port,omitempty"`        // 探测目标端口，icmp没有端口
+	PingType   string          `json:"ping_type"`             // 打流类型
+	IcmpId     *int            `json:"icmp_id,omitempty"`     // icmp请求ID，只有icmp才有
+	IcmpSeq    *int            `json:"icmp_seq,omitempty"`    // icmp序列号，只有icmp才有
+	Outcome    string          `json:"outcome"`               // 探测结果，success/fail
+	LatencyMs  *float64        `json:"latency_ms,omitempty"`  // 时延，单位毫秒，只有成功时才有
+	Reason     string          `json:"reason,omitempty"`      // 失败原因，只有失败时才有
+	Detail     string          `json:"detail,omitempty"`      // 失败详情，比如http响应哪一项校验没有通过
+	DnsRcode   string          `json:"dns_rcode,omitempty"`   // dns应答码，只有dns打流收到应答时才有
+	DnsAnswers []string        `json:"dns_answers,omitempty"` // dns应答记录，只有dns打流收到应答时才有
+	HttpTiming *JsonHttpTiming `json:"http_timing,omitempty"` // http打流各阶段的耗时，只有http打流收到响应时才有
+	Tls        *JsonTlsInfo    `json:"tls,omitempty"`         // tls打流的证书信息，只有tls打流握手成功时才有
+	Redirects  []string        `json:"redirects,omitempty"`   // http打流依次跳转到的url，只有跳转时才有
+	ReplyTtl   int             `json:"reply_ttl,omitempty"`   // icmp、tcp-syn打流收到的回复的TTL（ipv6为hop limit），读不到时没有
+	Hops       *int            `json:"hops,omitempty"`        // 根据回复TTL估算的跳数，读不到TTL时没有
+	PortState  string          `json:"port_state,omitempty"`  // udp打流没有任何回复、按--udp.silence.ok算成功时为open|filtered
+}
+
+// HopChangeEvent 跳数变化事件，icmp、tcp-syn打流一个目标的跳数确认发生变化时输出，一般是路由变了
+type HopChangeEvent struct {
+	Time     string `json:"time"`      // 事件时间，RFC3339Nano格式
+	TaskId   string `json:"task_id"`   // 大任务id
+	Event    string `json:"event"`     // 事件类型，固定为hop_change，探测事件没有这个字段
+	Target   string `json:"target"`    // 目标，格式同json输出的target
+	FromHops int    `json:"from_hops"` // 变化前的跳数
+	ToHops   int    `json:"to_hops"`   // 变化后的跳数
+}
+
+// newProbeEvent 根据任务和探测结果生成探测事件
+func newProbeEvent(taskId string, routineId int, item *TaskItem, result ping.PingResult) *ProbeEvent {
+	event := &ProbeEvent{
+		Time:      time.Now().Format(time.RFC3339Nano),
+		TaskId:    taskId,
+		RoutineId: routineId,
+		BatchId:   item.Id,
+		Target:    item.DstTarget,
+		PingType:  item.PingType,
+		Outcome:   ProbeOutcomeFail,
+	}
+	if item.PingType == utils.PingTypeHTTP {
+		// 与统计的key一致，同一个url的多行指定了不同的选项时可以区分
+		event.Target = item.HttpKey
+	}
+	if item.PingType == utils.PingTypeICMP {
+		icmpId, icmpSeq := item.IcmpId, item.IcmpSeq
+		event.IcmpId = &icmpId
+		event.IcmpSeq = &icmpSeq
+	} else {
+		event.Port = item.DstPort
+	}
+	if result.Silent {
+		// 没有回复，没有时延
+		event.Outcome = ProbeOutcomeSuccess
+		event.PortState = PortStateOpenFiltered
+	} else if result.Success {
+		event.Outcome = ProbeOutcomeSuccess
+		latencyMs := toMs(result.Rtt)
+		event.LatencyMs = &latencyMs
+	} else {
+		event.Reason = result.Reason
+		event.Detail = result.Detail
+	}
+	if result.Http != nil {
+		event.HttpTiming = newJsonHttpTiming(HttpTimingStat{Number: 1, Dns: result.Http.Dns, Connect: result.Http.Connect, Tls: result.Http.Tls, Ttfb: result.Http.Ttfb, Transfer: result.Http.Transfer})
+	}
+	event.Redirects = result.Redirects
+	event.Tls = newJsonTlsInfo(result.Tls)
+	if hops := ping.EstimateHops(result.Ttl); hops >= 0 {
+		event.ReplyTtl = result.Ttl
+		event.Hops = &hops
+	}
+	if result.Dns != nil {
+		event.DnsRcode = result.Dns.Rcode
+		event.DnsAnswers = result.Dns.Answers
+	}
+	return event
+}
+
+// emitProbeEvent ndjson展示模式下，输出一行探测事件
+func emitProbeEvent(paramInput ParamInput, event *ProbeEvent) {
+	if paramInput.ShowMode != utils.ShowModeNdjson {
+		return
+	}
+	content, err := json.Marshal(event)
+	if err != nil {
+		utils.Log.Errorln("探测事件编码出错", err)
+		return
+	}
+	eventMutex.Lock()
+	fmt.Println(string(content))
+	eventMutex.Unlock()
+}
+
+// emitHopChangeEvents ndjson展示模式下，每个跳数变化输出一行事件；其他展示模式也要取出，避免堆积
+func emitHopChangeEvents(paramInput ParamInput, fr *FailRate, taskId string) {
+	hopChangeList := fr.TakeHopChanges()
+	if paramInput.ShowMode != utils.ShowModeNdjson {
+		return
+	}
+	for _, hopChange := range hopChangeList {
+		content, err := json.Marshal(HopChangeEvent{
+			Time:     time.Now().Format(time.RFC3339Nano),
+			TaskId:   taskId,
+			Event:    EventHopChange,
+			Target:   hopChange.Target,
+			FromHops: hopChange.From,
+			ToHops:   hopChange.To,
+		})
+		if err != nil {
+			utils.Log.Errorln("跳数变化事件编码出错", err)
+			continue
+		}
+		eventMutex.Lock()
+		fmt.Println(string(content))
+		eventMutex.Unlock()
+	}
+}
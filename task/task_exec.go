@@ -11,19 +11,55 @@ import (
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 	"net"
+	"runtime"
+	"strings"
 	"sync"
 	"time"
 )
 
-// TaskLoop 循环执行每个探测任务
-func TaskLoop(taskList *RoutineTaskItem, wg *sync.WaitGroup, fr *FailRate, taskId string, paramInput ParamInput, ctx context.Context) {
+// TaskLoop 循环执行每个探测任务，httpTransportCache为http长连接模式下这个协程复用的连接，由调度函数创建和关闭，其他情况为nil
+func TaskLoop(taskList *RoutineTaskItem, wg *sync.WaitGroup, fr *FailRate, taskId string, paramInput ParamInput, ctx context.Context, httpTransportCache *ping.HttpTransportCache) {
 	defer wg.Done() // goroutine结束就登记-1
-	// 颜色渲染字体
-	red := color.New(color.FgRed).SprintFunc()
-	green := color.New(color.FgGreen).SprintFunc()
 	routineId := taskList.RoutineId
 	taskListLength := len(taskList.TaskItemList)
 	taskIndex := 0
+	// tcp打流的发送内容和期望的响应
+	tcpOption := genTcpOption(paramInput)
+	// udp发包内容，参数已经校验过
+	udpPayload, _ := utils.ParsePayload(paramInput.UdpPayload)
+	// TLS配置，参数已经校验过
+	tlsConfig, _ := ping.NewTlsConfig(paramInput.TlsSkipVerify, paramInput.TlsCaFile, paramInput.TlsSni)
+	// dns期望的应答记录
+	var dnsExpect []string
+	if paramInput.DnsExpect != "" {
+		dnsExpect = strings.Split(paramInput.DnsExpect, ",")
+	}
+	// 每种打流类型的探测函数
+	var probe func(item *TaskItem) ping.PingResult
+	switch paramInput.PingType {
+	case utils.PingTypeTCP:
+		probe = func(item *TaskItem) ping.PingResult {
+			return ping.TcpPing(item.DstTarget, item.DstPort, item.Timeout, item.SrcIp, tcpOption)
+		}
+	case utils.PingTypeUDP:
+		probe = func(item *TaskItem) ping.PingResult {
+			return ping.UdpPing(item.DstTarget, item.DstPort, item.Timeout, item.SrcIp, udpPayload, paramInput.UdpSilenceOk)
+		}
+	case utils.PingTypeDNS:
+		probe = func(item *TaskItem) ping.PingResult {
+			return ping.DnsPing(item.DstTarget, item.DstPort, item.Timeout, item.SrcIp, paramInput.DnsName, paramInput.DnsType, paramInput.DnsProto, dnsExpect)
+		}
+	case utils.PingTypeTLS:
+		probe = func(item *TaskItem) ping.PingResult {
+			return ping.TlsPing(item.DstTarget, item.DstPort, item.Timeout, item.SrcIp, tlsConfig, paramInput.TlsWarnDays)
+		}
+	case utils.PingTypeHTTP:
+		probe = func(item *TaskItem) ping.PingResult {
+			return ping.HttpPing(item.DstTarget, item.Timeout, item.SrcIp, tlsConfig, item.HttpOption, httpTransportCache)
+		}
+	default:
+		return
+	}
 	for {
 		select {
 		case <-ctx.Done():
@@ -33,45 +69,54 @@ func TaskLoop(taskList *RoutineTaskItem, wg *sync.WaitGroup, fr *FailRate, taskI
 			if taskIndex >= taskListLength {
 				return
 			}
-			item := taskList.TaskItemList[taskIndex]
-			// tcp 打流
-			switch item.PingType {
-			case utils.PingTypeTCP:
-				r := ping.TcpPing(item.DstTarget, item.DstPort, item.Timeout, item.SrcIp)
-				colorOutPut := red("fail")
-				if r {
-					colorOutPut = green("success")
-				}
-				successNum, failNum := fr.Increment(fmt.Sprintf("%s|%d", item.DstTarget, item.DstPort), r)
-				if paramInput.ShowMode == utils.ShowModeWaterfall && paramInput.Number != 0 {
-					sprintf := fmt.Sprintf("第%02d-%06d批次\t%s\t%d\t%s\t失败率%.2f%%\t失败%d\t总共%d", routineId, item.Id, item.DstTarget, item.DstPort, colorOutPut, float64(failNum)*100/float64(failNum+successNum), failNum, failNum+successNum)
-					fmt.Println(sprintf)
-				}
-			case utils.PingTypeHTTP:
-				r := ping.HttpPing(item.DstTarget, item.Timeout, item.SrcIp)
-				colorOutPut := red("fail")
-				if r {
-					colorOutPut = green("success")
-				}
-				successNum, failNum := fr.Increment(item.DstTarget, r)
-				if paramInput.ShowMode == utils.ShowModeWaterfall && paramInput.Number != 0 {
-					sprintf := fmt.Sprintf("第%02d-%06d批次\t%s\t%s\t失败率%.2f%%\t失败%d\t总共%d", routineId, item.Id, item.DstTarget, colorOutPut, float64(failNum)*100/float64(failNum+successNum), failNum, failNum+successNum)
-					fmt.Println(sprintf)
-				}
-			}
-
+			runProbe(paramInput, fr, taskId, routineId, taskList.TaskItemList[taskIndex], probe)
 			// 自增，循环知道这个goroutine执行完所有任务
 			taskIndex++
 		}
 	}
 }
 
+// runProbe 执行一次探测，记录结果，瀑布展示时输出一行，ndjson展示时输出一行探测事件
+func runProbe(paramInput ParamInput, fr *FailRate, taskId string, routineId int, item *TaskItem, probe func(item *TaskItem) ping.PingResult) {
+	// 颜色渲染字体
+	red := color.New(color.FgRed).SprintFunc()
+	green := color.New(color.FgGreen).SprintFunc()
+	r := probe(item)
+	// 统计的key和瀑布展示的目标列，http为url加上文件的行指定的选项，没有端口列
+	key := fmt.Sprintf("%s|%d", item.DstTarget, item.DstPort)
+	target := fmt.Sprintf("%s\t%d", item.DstTarget, item.DstPort)
+	// 瀑布展示时每种打流类型多展示的一列
+	extra := ""
+	switch item.PingType {
+	case utils.PingTypeDNS:
+		extra = "\t应答" + dnsString(r)
+	case utils.PingTypeTLS:
+		extra = "\t证书" + TlsString(r.Tls)
+	case utils.PingTypeHTTP:
+		key = item.HttpKey
+		target = item.HttpKey
+		extra = "\t阶段" + httpTimingString(r)
+	}
+	colorOutPut := red(outcomeString(r))
+	if r.Success {
+		colorOutPut = green(outcomeString(r))
+	}
+	successNum, failNum := fr.Increment(key, r)
+	if paramInput.ShowMode == utils.ShowModeWaterfall && paramInput.Number != 0 {
+		sprintf := fmt.Sprintf("第%02d-%06d批次\t%s\t%s\t时延%s%s\t失败率%.2f%%\t失败%d\t总共%d", routineId, item.Id, target, colorOutPut, rttString(r), extra, float64(failNum)*100/float64(failNum+successNum), failNum, failNum+successNum)
+		fmt.Println(sprintf)
+	}
+	emitProbeEvent(paramInput, newProbeEvent(taskId, routineId, item, r))
+}
+
 // TaskLoopICMP 循环执行每个探测任务
-func TaskLoopICMP(taskList *RoutineTaskItem, wg *sync.WaitGroup, ctx context.Context, handle *icmp.PacketConn, handleV6 *icmp.PacketConn, wgSend *sync.WaitGroup) {
+func TaskLoopICMP(taskList *RoutineTaskItem, wg *sync.WaitGroup, ctx context.Context, handle net.PacketConn, handleV6 net.PacketConn, wgSend *sync.WaitGroup, fr *FailRate, idSeqIpMap *sync.Map, taskId string, paramInput ParamInput, round int) {
 	defer wg.Done()     // goroutine结束就登记-1
 	defer wgSend.Done() // goroutine结束就登记-1
 	//routineId := taskList.RoutineId
 	taskListLength := len(taskList.TaskItemList)
+	// 发包内容的大小和填充内容
+	icmpOption := GenIcmpOption(paramInput)
 	taskIndex := 0
 	for {
 		select {
@@ -86,7 +131,14 @@ func TaskLoopICMP(taskList *RoutineTaskItem, wg *sync.WaitGroup, ctx context.Con
 			// tcp 打流
 			switch item.PingType {
 			case utils.PingTypeICMP:
-				ping.IcmpPingSend(item.DstTarget, handle, handleV6, item.IcmpId, item.IcmpSeq, item.IcmpSendInterval)
+				r := ping.IcmpPingSend(item.DstTarget, handle, handleV6, item.IcmpId, item.IcmpSeq, item.IcmpSendInterval, round, icmpOption)
+				// 发包失败，不用等待回复，直接记录失败原因
+				if !r.Success {
+					key := fmt.Sprintf("%d|%d", item.IcmpId, item.IcmpSeq)
+					if value, loaded := idSeqIpMap.LoadAndDelete(key); loaded {
+						recordIcmpFail(paramInput, fr, taskId, value.(*icmpPendingItem), r)
+					}
+				}
 			}
 
 			// 自增，循环知道这个goroutine执行完所有任务
@@ -95,17 +147,152 @@ func TaskLoopICMP(taskList *RoutineTaskItem, wg *sync.WaitGroup, ctx context.Con
 	}
 }
 
+// recordIcmpFail 记录一个失败的icmp请求，发包失败、收到差错报文或者超时未收到回复时调用
+func recordIcmpFail(paramInput ParamInput, fr *FailRate, taskId string, pendingItem *icmpPendingItem, r ping.PingResult) {
+	// 颜色渲染字体
+	red := color.New(color.FgRed).SprintFunc()
+	dstIp := pendingItem.Item.DstTarget
+	successNum, failNum := fr.Increment(dstIp, r)
+	if paramInput.ShowMode == utils.ShowModeWaterfall && paramInput.Number != 0 {
+		sprintf := fmt.Sprintf("%s\t%s\t时延%s\t失败率%.2f%%\t失败%d\t总共%d", dstIp, red(outcomeString(r)), rttString(r), float64(failNum)*100/float64(failNum+successNum), failNum, failNum+successNum)
+		fmt.Println(sprintf)
+	}
+	emitProbeEvent(paramInput, newProbeEvent(taskId, pendingItem.RoutineId, pendingItem.Item, r))
+}
+
+// recordIcmpError 收到路由器或者目标返回的差错报文，找到引用的echo请求对应的任务，马上记录失败原因，不用等到超时
+func recordIcmpError(paramInput ParamInput, fr *FailRate, taskId string, idSeqIpMap *sync.Map, errorReply ping.IcmpErrorReply, round int) {
+	// 引用了发包信息时，不是本进程或者不是本轮发出的请求不处理
+	if errorReply.HasPayload && (errorReply.Payload.Foreign || errorReply.Payload.Round != round) {
+		utils.Log.Traceln("icmp error of other process or round:", errorReply.Result.Detail)
+		return
+	}
+	key := fmt.Sprintf("%d|%d", errorReply.Id, errorReply.Seq)
+	value, loaded := idSeqIpMap.LoadAndDelete(key)
+	if !loaded {
+		utils.Log.Traceln("icmp error of other process ping, key:", key, errorReply.Result.Detail)
+		return
+	}
+	recordIcmpFail(paramInput, fr, taskId, value.(*icmpPendingItem), errorReply.Result)
+}
+
+// icmpPendingItem 已发出、等待回复的icmp请求，收包时根据id|seq找到对应的任务
+type icmpPendingItem struct {
+	RoutineId int       // 发包的协程id
+	Item      *TaskItem // 对应的任务
+}
+
+// icmpReplyTracker 一个接收goroutine本轮收到的回复，用于发现重复、乱序、迟到的回复，v4、v6的接收函数共用
+type icmpReplyTracker struct {
+	receivedMap     map[string]*icmpPendingItem // 本轮已经收到回复的请求，包括超时以后才到的，再收到就是重复的回复
+	lateMap         map[string]string           // 之前的轮次已经计为迟到的回复，key带上轮次，value为目标，再收到就是重复的回复
+	lastSendTimeMap map[string]time.Time        // 每个目标已经收到回复的请求里最晚的发送时间，用于发现乱序的回复
+	payloadSize     int                         // 发包内容的字节数，回复的内容比这个短说明被截断了
+}
+
+// newIcmpReplyTracker 初始化一个空icmpReplyTracker，payloadSize为发包内容的字节数
+func newIcmpReplyTracker(payloadSize int) *icmpReplyTracker {
+	return &icmpReplyTracker{
+		payloadSize:     payloadSize,
+		receivedMap:     make(map[string]*icmpPendingItem),
+		lateMap:         make(map[string]string),
+		lastSendTimeMap: make(map[string]time.Time),
+	}
+}
+
+// handleEchoReply 处理收到的echo回复：其他进程的、迟到的、重复的只计数，乱序的计数后照常记录时延
+func (t *icmpReplyTracker) handleEchoReply(paramInput ParamInput, fr *FailRate, taskId string, idSeqIpMap *sync.Map, targetMap map[string]string, echo *icmp.Echo, meta ping.PacketMeta, dstIp string, round int) {
+	payload, fromGoPing := ping.ParseIcmpPayload(echo.Data)
+	// 不是本进程发出的，比如系统的ping、同一台机器上的其他go_ping
+	if !fromGoPing || payload.Foreign {
+		fr.IncrementForeign()
+		utils.Log.Traceln("other process ping, src ip:", dstIp, "id:", echo.ID, "seq:", echo.Seq)
+		return
+	}
+	// 非特权的icmp套接字收到的ID被内核改写过，以发包内容里的ID为准
+	key := fmt.Sprintf("%d|%d", payload.Id, echo.Seq)
+	// 之前的轮次超时以后才到的回复，持续打流时每一轮的id|seq相同，据此找到目标
+	if payload.Round != round {
+		lateKey := fmt.Sprintf("%d|%s", payload.Round, key)
+		if dstTarget, counted := t.lateMap[lateKey]; counted {
+			fr.IncrementDup(dstTarget)
+			utils.Log.Traceln("duplicate late reply, src ip:", dstIp, "key:", lateKey)
+			return
+		}
+		t.lateMap[lateKey] = targetMap[key]
+		fr.IncrementLate(targetMap[key])
+		utils.Log.Traceln("late reply, src ip:", dstIp, "round:", payload.Round)
+		return
+	}
+	value, exists := idSeqIpMap.LoadAndDelete(key)
+	if !exists {
+		if receivedItem, received := t.receivedMap[key]; received {
+			// 同一个请求收到多个回复
+			fr.IncrementDup(receivedItem.Item.DstTarget)
+			utils.Log.Traceln("duplicate reply, src ip:", dstIp, "key:", key)
+		} else {
+			utils.Log.Traceln("other process ping, src ip:", dstIp, "key:", key)
+		}
+		return
+	}
+	pendingItem := value.(*icmpPendingItem)
+	// 记下已经收到回复的请求，再收到就是重复的回复
+	t.receivedMap[key] = pendingItem
+	dstTarget := pendingItem.Item.DstTarget
+	// 回复被截断，比如链路上的设备只回复了一部分内容，或者回复比发包内容长，超过了接收缓冲区
+	if meta.Truncated || len(echo.Data) < t.payloadSize {
+		detail := fmt.Sprintf("回复被截断，收到%d字节，发送%d字节", len(echo.Data), t.payloadSize)
+		if meta.Truncated {
+			detail = fmt.Sprintf("回复超过接收缓冲区被截断，发送%d字节", t.payloadSize)
+		}
+		recordIcmpFail(paramInput, fr, taskId, pendingItem, ping.PingResult{Reason: ping.FailReasonTruncated, Detail: detail})
+		return
+	}
+	// 时延：收包时间减去发包内容里的发送时间戳
+	r := ping.PingResult{Success: true, Ttl: meta.Ttl, Rtt: meta.ReceiveTime.Sub(payload.SendTime)}
+	// 超时以后才到的回复，按超时计算
+	timeout := paramInput.Timeout
+	if timeout < 1 {
+		timeout = 1
+	}
+	if r.Rtt > time.Duration(timeout)*time.Second {
+		fr.IncrementLate(dstTarget)
+		recordIcmpFail(paramInput, fr, taskId, pendingItem, ping.PingResult{Reason: ping.FailReasonTimeout, Detail: fmt.Sprintf("超时以后才收到回复，时延%sms", durationMs(r.Rtt))})
+		return
+	}
+	// 发送时间比这个目标已经收到回复的请求还早，说明回复乱序了
+	if payload.SendTime.Before(t.lastSendTimeMap[dstTarget]) {
+		fr.IncrementReorder(dstTarget)
+	} else {
+		t.lastSendTimeMap[dstTarget] = payload.SendTime
+	}
+	successNum, failNum := fr.Increment(dstTarget, r)
+	if paramInput.ShowMode == utils.ShowModeWaterfall && paramInput.Number != 0 {
+		green := color.New(color.FgGreen).SprintFunc()
+		fmt.Println(fmt.Sprintf("%s\t%s\t时延%s\t失败率%.2f%%\t失败%d\t总共%d", dstIp, green("success"), rttString(r), float64(failNum)*100/float64(failNum+successNum), failNum, failNum+successNum))
+	}
+	emitProbeEvent(paramInput, newProbeEvent(taskId, pendingItem.RoutineId, pendingItem.Item, r))
+	emitHopChangeEvents(paramInput, fr, taskId)
+}
+
 // IcmpPingReceive icmp ping接收函数，1个goroutines执行的
-func IcmpPingReceive(paramInput ParamInput, c *icmp.PacketConn, wg *sync.WaitGroup, fr *FailRate, ctx context.Context, done chan struct{}, idSeqIpMap *sync.Map, doneReceive *sync.WaitGroup) {
+func IcmpPingReceive(paramInput ParamInput, c net.PacketConn, wg *sync.WaitGroup, fr *FailRate, ctx context.Context, done chan struct{}, idSeqIpMap *sync.Map, targetMap map[string]string, doneReceive *sync.WaitGroup, taskId string, round int) {
 	defer wg.Done() // goroutine结束就登记-1
 	defer doneReceive.Done()
-	// 颜色渲染字体
-	green := color.New(color.FgGreen).SprintFunc()
 	// 设置接收超时
 	timeout := paramInput.Timeout
 	if paramInput.Timeout < 1 {
 		timeout = 1
 	}
+	// 接收缓冲区按发包内容的大小分配，回复比发包内容短或者被截断时算失败
+	icmpOption := GenIcmpOption(paramInput)
+	// 本轮收到的回复，用于发现重复、乱序、迟到的回复
+	tracker := newIcmpReplyTracker(icmpOption.PayloadSize())
+	// 读取控制消息里的内核收包时间戳和TTL，Windows不支持
+	var oob []byte
+	if runtime.GOOS != "windows" {
+		oob = make([]byte, 128)
+	}
 	i := 0
 	for {
 		i++
@@ -126,9 +313,10 @@ func IcmpPingReceive(paramInput ParamInput, c *icmp.PacketConn, wg *sync.WaitGro
 		default:
 			// 准备接收回复
 			//fmt.Println(fmt.Sprintf("3---%d---time-%v-%v", i, time.Now(), time.Since(now)))
-			reply := make([]byte, 1500)
+			reply := make([]byte, icmpOption.ReplyBufferSize())
 			//fmt.Println(fmt.Sprintf("3.5---%d---time-%v-%v", i, time.Now(), time.Since(now)))
-			n, peer, err1 := c.ReadFrom(reply)
+			// 收包时间优先使用内核时间戳，不包含在套接字缓冲区里排队的时间
+			n, peer, meta, err1 := ping.ReadPacket(c, reply, oob)
 			//fmt.Println(fmt.Sprintf("4---%d---time-%v-%v", i, time.Now(), time.Since(now)))
 			//now = time.Now()
 			if err1 != nil {
@@ -149,34 +337,13 @@ func IcmpPingReceive(paramInput ParamInput, c *icmp.PacketConn, wg *sync.WaitGro
 			//fmt.Println(fmt.Sprintf("6---%d---time-%v-%v", i, time.Now(), time.Since(now)))
 			// 检查回复类型
 			if receivedMessage.Type == ipv4.ICMPTypeEchoReply {
-				echo, ok := receivedMessage.Body.(*icmp.Echo)
-				if ok {
-					//fmt.Println(fmt.Sprintf("7---%d---time-%v-%v", i, time.Now(), time.Since(now)))
-					id := echo.ID
-					seq := echo.Seq
-					key := fmt.Sprintf("%d|%d", id, seq)
-					value, exists := (*idSeqIpMap).Load(key)
-					dstIpOrDomain, _ := value.(string)
-					//fmt.Println(fmt.Sprintf("8---%d---time-%v-%v", i, time.Now(), time.Since(now)))
-					if exists {
-						// 写往fr
-						//fmt.Println(key)
-						(*idSeqIpMap).Delete(key)
-						//fmt.Println(fmt.Sprintf("9---%d---time-%v-%v", i, time.Now(), time.Since(now)))
-						successNum, failNum := fr.Increment(dstIpOrDomain, true)
-						// 打印调试日志
-						//fmt.Println(fmt.Sprintf("success receive dstIp: %s, successNum: %d, failNum: %d, %s", dstIp, successNum, failNum, time.Now().String()))
-						if paramInput.ShowMode == utils.ShowModeWaterfall && paramInput.Number != 0 {
-							sprintf := fmt.Sprintf("%s\t%s\t失败率%.2f%%\t失败%d\t总共%d", dstIp, green("success"), float64(failNum)*100/float64(failNum+successNum), failNum, failNum+successNum)
-							fmt.Println(sprintf)
-						}
-						//fmt.Println(fmt.Sprintf("10---%d---time-%v-%v", i, time.Now(), time.Since(now)))
-					} else {
-						utils.Log.Traceln("other process ping, src ip:", dstIp, "key:", key)
-					}
+				if echo, ok := receivedMessage.Body.(*icmp.Echo); ok {
+					tracker.handleEchoReply(paramInput, fr, taskId, idSeqIpMap, targetMap, echo, meta, dstIp, round)
 				} else {
 					utils.Log.Traceln("Error asserting Echo Reply Body", dstIp)
 				}
+			} else if errorReply, ok := ping.ParseIcmpError(reply[:n], false, dstIp); ok {
+				recordIcmpError(paramInput, fr, taskId, idSeqIpMap, errorReply, round)
 			} else {
 				utils.Log.Traceln("Got non-echo reply type:", receivedMessage.Type)
 			}
@@ -185,16 +352,23 @@ func IcmpPingReceive(paramInput ParamInput, c *icmp.PacketConn, wg *sync.WaitGro
 }
 
 // IcmpPingReceiveV6 icmp v6 ping接收函数，1个goroutines执行的
-func IcmpPingReceiveV6(paramInput ParamInput, c *icmp.PacketConn, wg *sync.WaitGroup, fr *FailRate, ctx context.Context, done chan struct{}, idSeqIpMap *sync.Map, doneReceive *sync.WaitGroup) {
+func IcmpPingReceiveV6(paramInput ParamInput, c net.PacketConn, wg *sync.WaitGroup, fr *FailRate, ctx context.Context, done chan struct{}, idSeqIpMap *sync.Map, targetMap map[string]string, doneReceive *sync.WaitGroup, taskId string, round int) {
 	defer wg.Done() // goroutine结束就登记-1
 	defer doneReceive.Done()
-	// 颜色渲染字体
-	green := color.New(color.FgGreen).SprintFunc()
 	// 设置接收超时
 	timeout := paramInput.Timeout
 	if paramInput.Timeout < 1 {
 		timeout = 1
 	}
+	// 接收缓冲区按发包内容的大小分配，回复比发包内容短或者被截断时算失败
+	icmpOption := GenIcmpOption(paramInput)
+	// 本轮收到的回复，用于发现重复、乱序、迟到的回复
+	tracker := newIcmpReplyTracker(icmpOption.PayloadSize())
+	// 读取控制消息里的内核收包时间戳和TTL，Windows不支持
+	var oob []byte
+	if runtime.GOOS != "windows" {
+		oob = make([]byte, 128)
+	}
 	i := 0
 	for {
 		i++
@@ -215,9 +389,10 @@ func IcmpPingReceiveV6(paramInput ParamInput, c *icmp.PacketConn, wg *sync.WaitG
 		default:
 			// 准备接收回复
 			//fmt.Println(fmt.Sprintf("3---%d---time-%v-%v", i, time.Now(), time.Since(now)))
-			reply := make([]byte, 1500)
+			reply := make([]byte, icmpOption.ReplyBufferSize())
 			//fmt.Println(fmt.Sprintf("3.5---%d---time-%v-%v", i, time.Now(), time.Since(now)))
-			n, peer, err1 := c.ReadFrom(reply)
+			// 收包时间优先使用内核时间戳，不包含在套接字缓冲区里排队的时间
+			n, peer, meta, err1 := ping.ReadPacket(c, reply, oob)
 			//fmt.Println(fmt.Sprintf("4---%d---time-%v-%v", i, time.Now(), time.Since(now)))
 			//now = time.Now()
 			if err1 != nil {
@@ -238,37 +413,212 @@ func IcmpPingReceiveV6(paramInput ParamInput, c *icmp.PacketConn, wg *sync.WaitG
 			//fmt.Println(fmt.Sprintf("6---%d---time-%v-%v", i, time.Now(), time.Since(now)))
 			// 检查回复类型
 			if receivedMessage.Type == ipv6.ICMPTypeEchoReply {
-				echo, ok := receivedMessage.Body.(*icmp.Echo)
-				if ok {
-					//fmt.Println(fmt.Sprintf("7---%d---time-%v-%v", i, time.Now(), time.Since(now)))
-					id := echo.ID
-					seq := echo.Seq
-					key := fmt.Sprintf("%d|%d", id, seq)
-					value, exists := (*idSeqIpMap).Load(key)
-					dstIpOrDomain, _ := value.(string)
-					//fmt.Println(fmt.Sprintf("8---%d---time-%v-%v", i, time.Now(), time.Since(now)))
-					if exists {
-						// 写往fr
-						//fmt.Println(key)
-						(*idSeqIpMap).Delete(key)
-						//fmt.Println(fmt.Sprintf("9---%d---time-%v-%v", i, time.Now(), time.Since(now)))
-						successNum, failNum := fr.Increment(dstIpOrDomain, true)
-						// 打印调试日志
-						//fmt.Println(fmt.Sprintf("success receive dstIp: %s, successNum: %d, failNum: %d, %s", dstIp, successNum, failNum, time.Now().String()))
-						if paramInput.ShowMode == utils.ShowModeWaterfall && paramInput.Number != 0 {
-							sprintf := fmt.Sprintf("%s\t%s\t失败率%.2f%%\t失败%d\t总共%d", dstIp, green("success"), float64(failNum)*100/float64(failNum+successNum), failNum, failNum+successNum)
-							fmt.Println(sprintf)
-						}
-						//fmt.Println(fmt.Sprintf("10---%d---time-%v-%v", i, time.Now(), time.Since(now)))
-					} else {
-						utils.Log.Traceln("other process ping, src ip:", dstIp, "key:", key)
-					}
+				if echo, ok := receivedMessage.Body.(*icmp.Echo); ok {
+					tracker.handleEchoReply(paramInput, fr, taskId, idSeqIpMap, targetMap, echo, meta, dstIp, round)
 				} else {
 					utils.Log.Traceln("Error asserting Echo Reply Body", dstIp)
 				}
+			} else if errorReply, ok := ping.ParseIcmpError(reply[:n], true, dstIp); ok {
+				recordIcmpError(paramInput, fr, taskId, idSeqIpMap, errorReply, round)
 			} else {
 				utils.Log.Traceln("Got non-echo reply type:", receivedMessage.Type)
 			}
 		}
 	}
 }
+
+// TaskLoopTcpSyn 循环发送每个tcp-syn探测任务的SYN报文
+func TaskLoopTcpSyn(taskList *RoutineTaskItem, wg *sync.WaitGroup, ctx context.Context, handle *net.IPConn, handleV6 *net.IPConn, wgSend *sync.WaitGroup, fr *FailRate, portSeqMap *sync.Map, taskId string, paramInput ParamInput) {
+	defer wg.Done()     // goroutine结束就登记-1
+	defer wgSend.Done() // goroutine结束就登记-1
+	taskListLength := len(taskList.TaskItemList)
+	taskIndex := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			// 如果执行完了，就退出
+			if taskIndex >= taskListLength {
+				return
+			}
+			item := taskList.TaskItemList[taskIndex]
+			key := fmt.Sprintf("%d|%d", item.TcpSynSrcPort, item.TcpSynSeq)
+			dst, packet, err := ping.GenTcpSynPacket(item.DstTarget, item.DstPort, item.SrcIp, item.TcpSynSrcPort, item.TcpSynSeq)
+			if err != nil {
+				// 生成报文失败，不用等待回复，直接记录失败原因
+				if value, loaded := portSeqMap.LoadAndDelete(key); loaded {
+					recordTcpSynResult(paramInput, fr, taskId, value.(*tcpSynPendingItem), ping.PingResult{Reason: ping.ClassifyError(err)})
+				}
+			} else {
+				// 先记录发送时间，防止收包比记录还快
+				portSeqMap.Store(key, &tcpSynPendingItem{RoutineId: taskList.RoutineId, Item: item, SendTime: time.Now()})
+				r := ping.TcpSynSend(dst, packet, handle, handleV6, item.IcmpSendInterval)
+				// 发包失败，不用等待回复，直接记录失败原因
+				if !r.Success {
+					if value, loaded := portSeqMap.LoadAndDelete(key); loaded {
+						recordTcpSynResult(paramInput, fr, taskId, value.(*tcpSynPendingItem), r)
+					}
+				}
+			}
+			// 自增，循环知道这个goroutine执行完所有任务
+			taskIndex++
+		}
+	}
+}
+
+// tcpSynPendingItem 已发出、等待回复的SYN，收包时根据源端口|序列号找到对应的任务
+type tcpSynPendingItem struct {
+	RoutineId int       // 发包的协程id
+	Item      *TaskItem // 对应的任务
+	SendTime  time.Time // 发送时间，还没有发送时为零值
+}
+
+// recordTcpSynResult 记录一个tcp-syn探测结果，收到回复、发包失败或者超时未收到回复时调用
+func recordTcpSynResult(paramInput ParamInput, fr *FailRate, taskId string, pendingItem *tcpSynPendingItem, r ping.PingResult) {
+	// 颜色渲染字体
+	red := color.New(color.FgRed).SprintFunc()
+	green := color.New(color.FgGreen).SprintFunc()
+	item := pendingItem.Item
+	successNum, failNum := fr.Increment(fmt.Sprintf("%s|%d", item.DstTarget, item.DstPort), r)
+	if paramInput.ShowMode == utils.ShowModeWaterfall && paramInput.Number != 0 {
+		colorOutPut := red(outcomeString(r))
+		if r.Success {
+			colorOutPut = green(outcomeString(r))
+		}
+		sprintf := fmt.Sprintf("第%02d-%06d批次\t%s\t%d\t%s\t端口%s\t时延%s\t失败率%.2f%%\t失败%d\t总共%d", pendingItem.RoutineId, item.Id, item.DstTarget, item.DstPort, colorOutPut, portStateString(r), rttString(r), float64(failNum)*100/float64(failNum+successNum), failNum, failNum+successNum)
+		fmt.Println(sprintf)
+	}
+	emitProbeEvent(paramInput, newProbeEvent(taskId, pendingItem.RoutineId, item, r))
+	emitHopChangeEvents(paramInput, fr, taskId)
+}
+
+// TcpSynReceive tcp-syn打流接收函数，ipv4和ipv6各1个goroutines执行的
+// 收到SYN-ACK为端口开放，收到RST为端口关闭，内核会对SYN-ACK回RST，不会完成握手
+// 收到lateSeqMap里上一轮SYN的回复时计为迟到，不计入本轮
+func TcpSynReceive(paramInput ParamInput, c *net.IPConn, wg *sync.WaitGroup, fr *FailRate, ctx context.Context, done chan struct{}, portSeqMap *sync.Map, lateSeqMap *sync.Map, doneReceive *sync.WaitGroup, taskId string) {
+	defer wg.Done() // goroutine结束就登记-1
+	defer doneReceive.Done()
+	// 设置接收超时
+	timeout := paramInput.Timeout
+	if paramInput.Timeout < 1 {
+		timeout = 1
+	}
+	reply := make([]byte, 1500)
+	// 读取控制消息里的TTL，用于估算跳数
+	oob := make([]byte, 128)
+	for {
+		err := c.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+		if err != nil {
+			utils.Log.Errorln("SetReadDeadline error: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			// 所有发包都已经完成
+			return
+		default:
+			// 准备接收回复，原始套接字会收到本机所有的tcp报文，只处理对本进程SYN的回复
+			n, peer, meta, err1 := ping.ReadPacket(c, reply, oob)
+			receiveTime := time.Now()
+			if err1 != nil {
+				if netErr, ok := err1.(*net.OpError); ok && netErr.Timeout() {
+					utils.Log.Traceln("timeout error:", err1)
+					continue
+				}
+				utils.Log.Errorln("ReadFrom error:", err1)
+				continue
+			}
+			synReply, ok := ping.ParseTcpSynReply(reply[:n])
+			if !ok {
+				continue
+			}
+			key := fmt.Sprintf("%d|%d", synReply.DstPort, synReply.Seq)
+			value, exists := portSeqMap.Load(key)
+			if !exists {
+				// 上一轮已经按被过滤记录的SYN，回复迟到了
+				if target, late := lateSeqMap.LoadAndDelete(key); late {
+					fr.IncrementLate(target.(string))
+				}
+				continue
+			}
+			pendingItem := value.(*tcpSynPendingItem)
+			if pendingItem.Item.DstPort != synReply.SrcPort || pendingItem.SendTime.IsZero() {
+				utils.Log.Traceln("other process tcp, src ip:", peer.String(), "key:", key)
+				continue
+			}
+			if _, loaded := portSeqMap.LoadAndDelete(key); !loaded {
+				continue
+			}
+			r := ping.PingResult{Reason: ping.FailReasonRefused, Ttl: meta.Ttl}
+			if synReply.Open {
+				r = ping.PingResult{Success: true, Rtt: receiveTime.Sub(pendingItem.SendTime), Ttl: meta.Ttl}
+			}
+			recordTcpSynResult(paramInput, fr, taskId, pendingItem, r)
+		}
+	}
+}
+
+// rttString 瀑布展示用，失败时展示-
+func rttString(r ping.PingResult) string {
+	if !r.Success || r.Silent {
+		return "-"
+	}
+	return durationMs(r.Rtt) + "ms"
+}
+
+// outcomeString 瀑布展示用，失败时带上失败原因和详情，udp没有回复按成功计算时带上端口状态
+func outcomeString(r ping.PingResult) string {
+	if r.Silent {
+		return "success(open|filtered)"
+	}
+	if r.Success {
+		return "success"
+	}
+	if r.Detail != "" {
+		return fmt.Sprintf("fail(%s:%s)", r.Reason, r.Detail)
+	}
+	return fmt.Sprintf("fail(%s)", r.Reason)
+}
+
+// portStateString 瀑布展示用，tcp-syn打流的端口状态：收到SYN-ACK为open，收到RST为closed，没有回复为filtered
+func portStateString(r ping.PingResult) string {
+	switch {
+	case r.Success:
+		return "open"
+	case r.Reason == ping.FailReasonRefused:
+		return "closed"
+	case r.Reason == ping.FailReasonFiltered:
+		return "filtered"
+	}
+	return "-"
+}
+
+// dnsString 瀑布展示用，应答码和应答记录，没有收到应答时展示-
+func dnsString(r ping.PingResult) string {
+	if r.Dns == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%s[%s]", r.Dns.Rcode, strings.Join(r.Dns.Answers, ","))
+}
+
+// httpTimingString 瀑布展示用，http打流各阶段的耗时dns/connect/tls/ttfb/transfer，没有收到响应时展示-
+func httpTimingString(r ping.PingResult) string {
+	if r.Http == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%s/%s/%s/%s/%sms", durationMs(r.Http.Dns), durationMs(r.Http.Connect), durationMs(r.Http.Tls), durationMs(r.Http.Ttfb), durationMs(r.Http.Transfer))
+}
+
+// TlsString 展示用，TLS版本、加密套件、证书剩余天数，证书在告警天数内过期时带上即将过期，没有证书信息时展示-
+func TlsString(tlsResult *ping.TlsResult) string {
+	if tlsResult == nil {
+		return "-"
+	}
+	content := fmt.Sprintf("%s %s 剩余%d天", tlsResult.Version, tlsResult.Cipher, tlsResult.DaysLeft)
+	if tlsResult.Expiring {
+		content += "(即将过期)"
+	}
+	return content
+}
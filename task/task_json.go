@@ -0,0 +1,452 @@
+// Package task 本包提供json格式的结果输出，适用于对接系统
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"go_ping/ping"
+	"go_ping/utils"
+	"sort"
+	"time"
+)
+
+// JsonSchemaVersion json结果文档的版本号，字段有不兼容变化时递增
+const JsonSchemaVersion = 1
+
+// JsonResult json展示模式下的结果文档
+// 指定打流次数时，整个运行结束后输出一个文档；持续打流时，每一轮结束后输出一个文档，一行一个
+type JsonResult struct {
+	SchemaVersion     int              `json:"schema_version"`                 // 文档版本号
+	TaskId            string           `json:"task_id"`                        // 大任务id，即TaskList.TaskId
+	Round             int              `json:"round"`                          // 持续打流的轮次，从1开始；指定打流次数时为0
+	Param             ParamInput       `json:"param"`                          // 用户输入的参数
+	StartTime         string           `json:"start_time"`                     // 开始时间，RFC3339格式
+	EndTime           string           `json:"end_time"`                       // 结束时间，RFC3339格式
+	DurationMs        int64            `json:"duration_ms"`                    // 花费时间，单位毫秒
+	PlanNumber        int              `json:"plan_number"`                    // 计划发包数
+	SuccessNumber     int              `json:"success_number"`                 // 成功数
+	FailNumber        int              `json:"fail_number"`                    // 失败数
+	TotalNumber       int              `json:"total_number"`                   // 已发包数
+	FailPercent       float64          `json:"fail_percent"`                   // 失败占比，取值[0~100]
+	Rtt               *JsonRttStat     `json:"rtt,omitempty"`                  // 所有目标的时延统计，没有成功的探测时没有
+	HttpTiming        *JsonHttpTiming  `json:"http_timing,omitempty"`          // 所有目标http打流各阶段的平均耗时，其他打流类型没有
+	IcmpOption        *JsonIcmpOption  `json:"icmp_option,omitempty"`          // icmp打流实际生效的发包选项，其他打流类型没有
+	FailReasons       map[string]int   `json:"fail_reasons"`                   // 所有目标每种失败原因的次数
+	LocalFailNumber   int              `json:"local_fail_number"`              // 本机资源耗尽导致的失败数，不代表目标异常
+	LateNumber        int              `json:"late_number"`                    // icmp、tcp-syn打流超时以后才到的回复数，按超时计算，不计入成功数
+	DupNumber         int              `json:"dup_number"`                     // icmp打流重复的回复数
+	ReorderNumber     int              `json:"reorder_number"`                 // icmp打流乱序的回复数
+	ForeignNumber     int              `json:"foreign_number"`                 // icmp打流收到的不是本进程发出的回复数
+	Targets           []JsonTargetItem `json:"targets"`                        // 每个目标的统计，按目标排序
+	FromSuccessToFail []string         `json:"from_success_to_fail,omitempty"` // 持续打流时，本轮由成功变为失败的目标
+	FromFailToSuccess []string         `json:"from_fail_to_success,omitempty"` // 持续打流时，本轮由失败变为成功的目标
+	HopChanges        []JsonHopChange  `json:"hop_changes,omitempty"`          // icmp、tcp-syn打流本轮跳数发生变化的目标，一般是路由变了
+}
+
+// JsonTargetItem 每个目标的统计
+// Target 与FailRate.ResultMap的key一致：tcp为IP|PORT，http为http://IP:PORT，文件的行指定了选项时再加上选项，icmp为IP或域名
+type JsonTargetItem struct {
+	Target        string          `json:"target"`
+	SuccessNumber int             `json:"success_number"`
+	FailNumber    int             `json:"fail_number"`
+	TotalNumber   int             `json:"total_number"`
+	FailPercent   float64         `json:"fail_percent"`
+	Rtt           *JsonRttStat    `json:"rtt,omitempty"`         // 时延统计，没有成功的探测时没有
+	FailReasons   map[string]int  `json:"fail_reasons"`          // 每种失败原因的次数
+	Dns           *JsonDnsStat    `json:"dns,omitempty"`         // dns打流的应答统计，其他打流类型没有
+	HttpTiming    *JsonHttpTiming `json:"http_timing,omitempty"` // http打流各阶段的平均耗时，其他打流类型没有
+	Tls           *JsonTlsInfo    `json:"tls,omitempty"`         // tls打流最近一次握手的证书信息，其他打流类型没有
+	Redirects     map[string]int  `json:"redirects,omitempty"`   // http打流每种跳转链的次数，url之间用" -> "连接，没有跳转时没有
+	IcmpReply     *JsonIcmpReply  `json:"icmp_reply,omitempty"`  // icmp打流重复、乱序、迟到的回复数，其他打流类型没有
+	Hops          *int            `json:"hops,omitempty"`        // icmp、tcp-syn打流根据回复TTL估算的跳数，没有收到回复时没有
+}
+
+// JsonHopChange 一个目标的跳数变化
+type JsonHopChange struct {
+	Target   string `json:"target"`    // 目标，与targets里的target一致
+	FromHops int    `json:"from_hops"` // 变化前的跳数
+	ToHops   int    `json:"to_hops"`   // 变化后的跳数
+}
+
+// JsonIcmpReply icmp打流回复的异常统计
+type JsonIcmpReply struct {
+	DupNumber     int `json:"dup_number"`     // 同一个请求收到多个回复时，多出来的回复数
+	ReorderNumber int `json:"reorder_number"` // 比同一个目标后发的请求更晚收到的回复数
+	LateNumber    int `json:"late_number"`    // 超时以后才到的回复数
+}
+
+// JsonTrace 逐跳探测一个目标的结果，json、ndjson模式下每个目标完成后输出一行
+type JsonTrace struct {
+	SchemaVersion int            `json:"schema_version"`  // 文档版本号
+	TaskId        string         `json:"task_id"`         // 大任务id
+	Target        string         `json:"target"`          // 目标，与文件或者-d里的一致
+	Ip            string         `json:"ip"`              // 目标解析后的IP，解析失败时为空
+	Port          int            `json:"port,omitempty"`  // tcp逐跳探测的目标端口，其他协议没有
+	Proto         string         `json:"proto"`           // 探测协议，icmp/udp/tcp
+	Reached       bool           `json:"reached"`         // 是否到达了目标
+	Error         string         `json:"error,omitempty"` // 目标解析失败等错误
+	Hops          []JsonTraceHop `json:"hops"`            // 每一跳的结果
+}
+
+// JsonTraceHop 逐跳探测一跳的结果
+type JsonTraceHop struct {
+	Ttl    int              `json:"ttl"`    // 发包的TTL，即第几跳
+	Probes []JsonTraceProbe `json:"probes"` // 这一跳每次探测的结果
+}
+
+// JsonTraceProbe 逐跳探测一次探测的结果
+type JsonTraceProbe struct {
+	Router string   `json:"router,omitempty"` // 回复的地址，没有收到回复时没有
+	RttMs  *float64 `json:"rtt_ms,omitempty"` // 时延，单位毫秒，没有收到回复时没有
+	Reason string   `json:"reason,omitempty"` // 没有收到回复时为timeout，路由器返回目标不可达等差错时为对应的原因
+	Detail string   `json:"detail,omitempty"` // 失败详情
+}
+
+// JsonMtr 持续逐跳探测一个目标的累计统计，json、ndjson模式下每一轮每个目标输出一行
+type JsonMtr struct {
+	SchemaVersion int              `json:"schema_version"`  // 文档版本号
+	TaskId        string           `json:"task_id"`         // 大任务id
+	Round         int              `json:"round"`           // 轮次，从1开始
+	Time          string           `json:"time"`            // 本轮结束的时间，RFC3339格式
+	Target        string           `json:"target"`          // 目标，与文件或者-d里的一致
+	Ip            string           `json:"ip"`              // 目标解析后的IP，解析失败时为空
+	Port          int              `json:"port,omitempty"`  // tcp逐跳探测的目标端口，其他协议没有
+	Proto         string           `json:"proto"`           // 探测协议，icmp/udp/tcp
+	Reached       bool             `json:"reached"`         // 是否到达过目标
+	Error         string           `json:"error,omitempty"` // 目标解析失败等错误
+	Hops          []JsonMtrHopStat `json:"hops"`            // 每一跳的累计统计，到目标为止
+}
+
+// JsonMtrHopStat 持续逐跳探测一跳的累计统计，时延单位毫秒，没有收到回复时没有时延
+type JsonMtrHopStat struct {
+	Ttl            int            `json:"ttl"`                // 发包的TTL，即第几跳
+	Routers        []string       `json:"routers"`            // 回复的地址，按次数从多到少排序
+	SentNumber     int            `json:"sent_number"`        // 发包数
+	ReceivedNumber int            `json:"received_number"`    // 收到回复数
+	LossPercent    float64        `json:"loss_percent"`       // 丢包率，取值[0~100]
+	LastMs         *float64       `json:"last_ms,omitempty"`  // 最近一次的时延
+	AvgMs          *float64       `json:"avg_ms,omitempty"`   // 平均时延
+	BestMs         *float64       `json:"best_ms,omitempty"`  // 最小时延
+	WorstMs        *float64       `json:"worst_ms,omitempty"` // 最大时延
+	StdevMs        *float64       `json:"stdev_ms,omitempty"` // 时延的标准差
+	FailReasons    map[string]int `json:"fail_reasons"`       // 每种失败原因的次数，包括超时
+}
+
+// JsonDnsStat dns打流的应答统计
+type JsonDnsStat struct {
+	Rcodes  map[string]int `json:"rcodes"`  // 每种应答码的次数
+	Answers map[string]int `json:"answers"` // 每种应答记录集合的次数，记录之间用逗号分隔
+}
+
+// JsonRttStat 时延统计，单位毫秒
+type JsonRttStat struct {
+	MinMs  float64 `json:"min_ms"`
+	AvgMs  float64 `json:"avg_ms"`
+	MaxMs  float64 `json:"max_ms"`
+	MdevMs float64 `json:"mdev_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+}
+
+// JsonHttpTiming http打流各阶段的耗时，单位毫秒
+type JsonHttpTiming struct {
+	DnsMs      float64 `json:"dns_ms"`
+	ConnectMs  float64 `json:"connect_ms"`
+	TlsMs      float64 `json:"tls_ms"`
+	TtfbMs     float64 `json:"ttfb_ms"`
+	TransferMs float64 `json:"transfer_ms"`
+}
+
+// JsonTlsInfo tls打流握手的结果和服务端证书的信息
+type JsonTlsInfo struct {
+	Version    string `json:"version"`               // 协商的TLS版本
+	Cipher     string `json:"cipher"`                // 协商的加密套件
+	Subject    string `json:"subject"`               // 叶子证书的CN
+	NotAfter   string `json:"not_after"`             // 叶子证书的过期时间，RFC3339格式
+	DaysLeft   int    `json:"days_left"`             // 叶子证书剩余的天数，已过期时为负数
+	Expiring   bool   `json:"expiring"`              // 叶子证书在--tls.warn.days天内过期
+	NameMatch  bool   `json:"name_match"`            // 叶子证书的SAN和SNI或者目标IP是否匹配
+	ChainValid bool   `json:"chain_valid"`           // 证书链是否可信
+	ChainError string `json:"chain_error,omitempty"` // 证书链不可信的原因
+}
+
+// JsonIcmpOption icmp打流连接上实际生效的发包选项
+type JsonIcmpOption struct {
+	Sock         string `json:"sock"`          // 套接字类型，raw或者dgram
+	Size         int    `json:"size"`          // 发包内容的字节数，不包含icmp头
+	Ttl          int    `json:"ttl"`           // ipv4的TTL
+	HopLimit     int    `json:"hop_limit"`     // ipv6的hop limit
+	Df           bool   `json:"df"`            // 是否设置了不分片
+	Tos          int    `json:"tos"`           // ipv4的TOS
+	TrafficClass int    `json:"traffic_class"` // ipv6的traffic class
+	Timestamp    string `json:"timestamp"`     // 收包时间的来源，kernel为内核时间戳，user为用户态时间
+}
+
+// newJsonIcmpOption 不是icmp打流时返回nil
+func newJsonIcmpOption(effective *ping.IcmpEffectiveOption) *JsonIcmpOption {
+	if effective == nil {
+		return nil
+	}
+	return &JsonIcmpOption{
+		Sock:         effective.Sock,
+		Size:         effective.Size,
+		Ttl:          effective.Ttl,
+		HopLimit:     effective.HopLimit,
+		Df:           effective.Df,
+		Tos:          effective.Tos,
+		TrafficClass: effective.TrafficClass,
+		Timestamp:    effective.Timestamp,
+	}
+}
+
+// newJsonTlsInfo 没有证书信息时返回nil
+func newJsonTlsInfo(tlsResult *ping.TlsResult) *JsonTlsInfo {
+	if tlsResult == nil {
+		return nil
+	}
+	return &JsonTlsInfo{
+		Version:    tlsResult.Version,
+		Cipher:     tlsResult.Cipher,
+		Subject:    tlsResult.Subject,
+		NotAfter:   tlsResult.NotAfter.Format(time.RFC3339),
+		DaysLeft:   tlsResult.DaysLeft,
+		Expiring:   tlsResult.Expiring,
+		NameMatch:  tlsResult.NameMatch,
+		ChainValid: tlsResult.ChainValid,
+		ChainError: tlsResult.ChainError,
+	}
+}
+
+// newJsonHttpTiming 没有耗时时返回nil
+func newJsonHttpTiming(stat HttpTimingStat) *JsonHttpTiming {
+	if stat.Number == 0 {
+		return nil
+	}
+	return &JsonHttpTiming{
+		DnsMs:      toMs(stat.Dns),
+		ConnectMs:  toMs(stat.Connect),
+		TlsMs:      toMs(stat.Tls),
+		TtfbMs:     toMs(stat.Ttfb),
+		TransferMs: toMs(stat.Transfer),
+	}
+}
+
+// newJsonRttStat 没有时延时返回nil
+func newJsonRttStat(stat RttStat) *JsonRttStat {
+	if stat.Number == 0 {
+		return nil
+	}
+	return &JsonRttStat{
+		MinMs:  toMs(stat.Min),
+		AvgMs:  toMs(stat.Avg),
+		MaxMs:  toMs(stat.Max),
+		MdevMs: toMs(stat.Mdev),
+		P50Ms:  toMs(stat.P50),
+		P90Ms:  toMs(stat.P90),
+		P99Ms:  toMs(stat.P99),
+	}
+}
+
+// newJsonTrace 逐跳探测一个目标的json结果
+func newJsonTrace(result TraceResult, taskId string, paramInput ParamInput) *JsonTrace {
+	jsonTrace := &JsonTrace{
+		SchemaVersion: JsonSchemaVersion,
+		TaskId:        taskId,
+		Target:        result.Target,
+		Ip:            result.Ip,
+		Port:          result.Port,
+		Proto:         paramInput.TraceProto,
+		Reached:       result.Reached,
+		Error:         result.Error,
+		Hops:          []JsonTraceHop{},
+	}
+	for _, hop := range result.HopList {
+		jsonHop := JsonTraceHop{Ttl: hop.Ttl, Probes: []JsonTraceProbe{}}
+		for _, probe := range hop.ProbeList {
+			jsonProbe := JsonTraceProbe{Router: probe.Router, Reason: probe.Reason, Detail: probe.Detail}
+			if probe.Router != "" {
+				rttMs := toMs(probe.Rtt)
+				jsonProbe.RttMs = &rttMs
+			}
+			jsonHop.Probes = append(jsonHop.Probes, jsonProbe)
+		}
+		jsonTrace.Hops = append(jsonTrace.Hops, jsonHop)
+	}
+	return jsonTrace
+}
+
+// newJsonMtr 持续逐跳探测一个目标的json结果
+func newJsonMtr(mtr *TraceMtr, taskId string, round int, paramInput ParamInput) *JsonMtr {
+	jsonMtr := &JsonMtr{
+		SchemaVersion: JsonSchemaVersion,
+		TaskId:        taskId,
+		Round:         round,
+		Time:          time.Now().Format(time.RFC3339),
+		Target:        mtr.Target,
+		Ip:            mtr.Ip,
+		Port:          mtr.Port,
+		Proto:         paramInput.TraceProto,
+		Reached:       mtr.Reached,
+		Error:         mtr.Error,
+		Hops:          []JsonMtrHopStat{},
+	}
+	if mtr.Error != "" {
+		return jsonMtr
+	}
+	for _, stat := range mtr.HopStatList[:mtr.PathLen] {
+		jsonStat := JsonMtrHopStat{
+			Ttl:            stat.Ttl,
+			Routers:        stat.Routers(),
+			SentNumber:     stat.SentNumber,
+			ReceivedNumber: stat.ReceivedNumber,
+			LossPercent:    failPercent(stat.SentNumber-stat.ReceivedNumber, stat.SentNumber),
+			FailReasons:    copyFailReasonMap(stat.FailReasonMap),
+		}
+		if stat.ReceivedNumber > 0 {
+			lastMs, avgMs, bestMs, worstMs, stdevMs := toMs(stat.Last), toMs(stat.Avg()), toMs(stat.Best), toMs(stat.Worst), toMs(stat.Stdev())
+			jsonStat.LastMs, jsonStat.AvgMs, jsonStat.BestMs, jsonStat.WorstMs, jsonStat.StdevMs = &lastMs, &avgMs, &bestMs, &worstMs, &stdevMs
+		}
+		jsonMtr.Hops = append(jsonMtr.Hops, jsonStat)
+	}
+	return jsonMtr
+}
+
+// ShowJson 指定打流次数时，所有任务完成后输出json结果
+func ShowJson(fr *FailRate, fl *FileTaskItemNumber, paramInput ParamInput, startTime time.Time) {
+	result := genJsonResult(fr, fl.TaskId, paramInput, fl.TaskNumber, startTime)
+	printJson(result)
+}
+
+// ShowJsonForever 持续打流时，每一轮结束后输出json结果，并清空本轮数据
+func ShowJsonForever(fr *FailRate, taskId string, round int, paramInput ParamInput, taskNum int, startTime time.Time) {
+	// 统计数据
+	fr.Statistic()
+	result := genJsonResult(fr, taskId, paramInput, taskNum, startTime)
+	result.Round = round
+	fr.mutex.Lock()
+	result.FromSuccessToFail = setToSortedStrings(fr.FromSuccessToFail.ToSlice())
+	result.FromFailToSuccess = setToSortedStrings(fr.FromFailToSuccess.ToSlice())
+	fr.mutex.Unlock()
+	printJson(result)
+	// 清空数据
+	fr.Clean()
+}
+
+func genJsonResult(fr *FailRate, taskId string, paramInput ParamInput, taskNum int, startTime time.Time) *JsonResult {
+	endTime := time.Now()
+	result := &JsonResult{
+		SchemaVersion: JsonSchemaVersion,
+		TaskId:        taskId,
+		Param:         paramInput,
+		StartTime:     startTime.Format(time.RFC3339),
+		EndTime:       endTime.Format(time.RFC3339),
+		DurationMs:    endTime.Sub(startTime).Milliseconds(),
+		PlanNumber:    taskNum,
+		IcmpOption:    newJsonIcmpOption(paramInput.IcmpEffective),
+		Targets:       []JsonTargetItem{},
+	}
+	// 加锁，读取探测结果
+	fr.mutex.Lock()
+	result.SuccessNumber = fr.SuccessNumber
+	result.FailNumber = fr.FailNumber
+	result.TotalNumber = fr.SuccessNumber + fr.FailNumber
+	result.FailPercent = failPercent(fr.FailNumber, result.TotalNumber)
+	result.LocalFailNumber = fr.LocalFailNumber
+	result.LateNumber = fr.LateNumber
+	result.ForeignNumber = fr.ForeignNumber
+	result.DupNumber = fr.DupNumber
+	result.ReorderNumber = fr.ReorderNumber
+	result.Rtt = newJsonRttStat(fr.rttStat())
+	result.HttpTiming = newJsonHttpTiming(fr.httpTimingStat())
+	result.FailReasons = copyFailReasonMap(fr.failReasonMap())
+	for key, failRateItem := range fr.ResultMap {
+		totalNum := failRateItem.SuccessNumber + failRateItem.FailNumber
+		var icmpReply *JsonIcmpReply
+		if paramInput.PingType == utils.PingTypeICMP {
+			icmpReply = &JsonIcmpReply{DupNumber: failRateItem.DupNumber, ReorderNumber: failRateItem.ReorderNumber, LateNumber: failRateItem.LateNumber}
+		}
+		var hops *int
+		if value, exists := fr.HopMap[key]; exists {
+			hops = &value
+		}
+		result.Targets = append(result.Targets, JsonTargetItem{
+			Target:        key,
+			SuccessNumber: failRateItem.SuccessNumber,
+			FailNumber:    failRateItem.FailNumber,
+			TotalNumber:   totalNum,
+			FailPercent:   failPercent(failRateItem.FailNumber, totalNum),
+			Rtt:           newJsonRttStat(failRateItem.RttAgg.Stat()),
+			FailReasons:   copyFailReasonMap(failRateItem.FailReasonMap),
+			Dns:           newJsonDnsStat(failRateItem),
+			HttpTiming:    newJsonHttpTiming(failRateItem.HttpTimingAgg.Stat()),
+			Redirects:     copyFailReasonMap(failRateItem.RedirectMap),
+			Tls:           newJsonTlsInfo(failRateItem.Tls),
+			IcmpReply:     icmpReply,
+			Hops:          hops,
+		})
+	}
+	for _, hopChange := range fr.HopChangeList {
+		result.HopChanges = append(result.HopChanges, JsonHopChange{Target: hopChange.Target, FromHops: hopChange.From, ToHops: hopChange.To})
+	}
+	fr.mutex.Unlock()
+	sort.Slice(result.Targets, func(i, j int) bool {
+		return result.Targets[i].Target < result.Targets[j].Target
+	})
+	return result
+}
+
+// printJson 输出一行json，result为JsonResult、JsonTrace等
+func printJson(result interface{}) {
+	content, err := json.Marshal(result)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(string(content))
+}
+
+// failPercent 计算失败占比，保留两位小数
+func failPercent(failNum int, totalNum int) float64 {
+	if totalNum == 0 {
+		return 0
+	}
+	return float64(int64(float64(failNum)*10000/float64(totalNum)+0.5)) / 100
+}
+
+// setToSortedStrings 把集合里的元素转换为排序后的字符串切片
+func setToSortedStrings(slice []interface{}) []string {
+	stringSlice := make([]string, 0, len(slice))
+	for _, elem := range slice {
+		str, ok := elem.(string)
+		if !ok {
+			continue
+		}
+		stringSlice = append(stringSlice, str)
+	}
+	sort.Strings(stringSlice)
+	return stringSlice
+}
+
+// copyFailReasonMap 复制失败原因等计数，去掉次数为0的
+func copyFailReasonMap(reasonMap map[string]int) map[string]int {
+	newReasonMap := make(map[string]int)
+	for reason, number := range reasonMap {
+		if number > 0 {
+			newReasonMap[reason] = number
+		}
+	}
+	return newReasonMap
+}
+
+// newJsonDnsStat 没有dns应答时返回nil
+func newJsonDnsStat(failRateItem *FailRateItem) *JsonDnsStat {
+	rcodes := copyFailReasonMap(failRateItem.DnsRcodeMap)
+	if len(rcodes) == 0 {
+		return nil
+	}
+	return &JsonDnsStat{
+		Rcodes:  rcodes,
+		Answers: copyFailReasonMap(failRateItem.DnsAnswerMap),
+	}
+}
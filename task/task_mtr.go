@@ -0,0 +1,277 @@
+// Package task 本包提供持续逐跳探测，每一轮向目标的每一跳各发一个探测，累计统计每一跳的丢包和时延，类似mtr
+package task
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+	"go_ping/utils"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceHopStat 持续逐跳探测一跳的累计统计
+type TraceHopStat struct {
+	Ttl            int            // 发包的TTL，即第几跳
+	SentNumber     int            // 发包数
+	ReceivedNumber int            // 收到回复数，包括路由器返回的TTL超时和目标不可达等差错
+	Last           time.Duration  // 最近一次收到回复的时延
+	Best           time.Duration  // 最小时延
+	Worst          time.Duration  // 最大时延
+	rttSum         float64        // 时延的和，用于计算平均值
+	rttSquareSum   float64        // 时延平方的和，用于计算标准差
+	RouterMap      map[string]int // 每个回复地址的次数，ECMP时一跳会有多个地址
+	FailReasonMap  map[string]int // 每种失败原因的次数，包括超时
+}
+
+// TraceMtr 持续逐跳探测一个目标的累计统计
+type TraceMtr struct {
+	Target      string          // 目标，与文件或者-d里的一致
+	Ip          string          // 目标解析后的IP
+	Port        int             // tcp逐跳探测的目标端口，其他协议为0
+	Reached     bool            // 是否到达过目标
+	Error       string          // 目标解析失败等错误，没有错误时为空
+	PathLen     int             // 到目标的跳数，到达目标或者收到目标不可达之前为最大跳数，路径变长时重新变大
+	HopStatList []*TraceHopStat // 每一跳的统计，下标为TTL减1
+	target      *traceTarget
+}
+
+// newTraceHopStat 初始化一跳的统计
+func newTraceHopStat(ttl int) *TraceHopStat {
+	return &TraceHopStat{
+		Ttl:           ttl,
+		RouterMap:     make(map[string]int),
+		FailReasonMap: make(map[string]int),
+	}
+}
+
+// add 记录一次探测的结果
+func (s *TraceHopStat) add(probe TraceProbe) {
+	s.SentNumber++
+	if probe.Reason != "" {
+		s.FailReasonMap[probe.Reason]++
+	}
+	if probe.Router == "" {
+		return
+	}
+	s.ReceivedNumber++
+	s.RouterMap[probe.Router]++
+	if s.ReceivedNumber == 1 || probe.Rtt < s.Best {
+		s.Best = probe.Rtt
+	}
+	if probe.Rtt > s.Worst {
+		s.Worst = probe.Rtt
+	}
+	s.Last = probe.Rtt
+	s.rttSum += float64(probe.Rtt)
+	s.rttSquareSum += float64(probe.Rtt) * float64(probe.Rtt)
+}
+
+// LossPercent 丢包率，取值[0~100]
+func (s *TraceHopStat) LossPercent() float64 {
+	if s.SentNumber == 0 {
+		return 0
+	}
+	return float64(s.SentNumber-s.ReceivedNumber) * 100 / float64(s.SentNumber)
+}
+
+// Avg 平均时延，没有收到回复时为0
+func (s *TraceHopStat) Avg() time.Duration {
+	if s.ReceivedNumber == 0 {
+		return 0
+	}
+	return time.Duration(s.rttSum / float64(s.ReceivedNumber))
+}
+
+// Stdev 时延的标准差，没有收到回复时为0
+func (s *TraceHopStat) Stdev() time.Duration {
+	if s.ReceivedNumber == 0 {
+		return 0
+	}
+	avg := s.rttSum / float64(s.ReceivedNumber)
+	variance := s.rttSquareSum/float64(s.ReceivedNumber) - avg*avg
+	if variance < 0 {
+		variance = 0
+	}
+	return time.Duration(math.Sqrt(variance))
+}
+
+// Routers 回复地址按次数从多到少排序，次数相同时按地址排序
+func (s *TraceHopStat) Routers() []string {
+	routers := make([]string, 0, len(s.RouterMap))
+	for router := range s.RouterMap {
+		routers = append(routers, router)
+	}
+	sort.Slice(routers, func(i, j int) bool {
+		if s.RouterMap[routers[i]] != s.RouterMap[routers[j]] {
+			return s.RouterMap[routers[i]] > s.RouterMap[routers[j]]
+		}
+		return routers[i] < routers[j]
+	})
+	return routers
+}
+
+// newTraceMtr 解析目标并初始化每一跳的统计，解析失败时记录错误，不再探测
+func (t *tracer) newTraceMtr(item TaskItem) *TraceMtr {
+	mtr := &TraceMtr{Target: item.DstTarget, PathLen: t.paramInput.TraceMaxHops}
+	if t.paramInput.TraceProto == utils.TraceProtoTcp {
+		mtr.Port = item.DstPort
+	}
+	target, err := t.newTraceTarget(item)
+	if err != nil {
+		mtr.Error = err.Error()
+		return mtr
+	}
+	mtr.target = target
+	mtr.Ip = target.Dst.IP.String()
+	for ttl := 1; ttl <= t.paramInput.TraceMaxHops; ttl++ {
+		mtr.HopStatList = append(mtr.HopStatList, newTraceHopStat(ttl))
+	}
+	return mtr
+}
+
+// mtrRound 一轮探测，向目标的每一跳各发一个探测，到达目标或者收到目标不可达后只探测到这一跳
+// 每隔TraceReprobeRounds轮探测到最大跳数；最后一跳回复了TTL超时，说明路径变长了，下一轮也探测到最大跳数
+func (t *tracer) mtrRound(mtr *TraceMtr, round int) {
+	if mtr.target == nil {
+		return
+	}
+	probeLen := mtr.PathLen
+	if round%utils.TraceReprobeRounds == 0 {
+		probeLen = t.paramInput.TraceMaxHops
+	}
+	ttlList := make([]int, probeLen)
+	for i := range ttlList {
+		ttlList[i] = i + 1
+	}
+	pathLen := 0
+	var lastProbe TraceProbe
+	for i, probe := range t.probe(mtr.target, ttlList) {
+		mtr.HopStatList[i].add(probe)
+		if isLastHop(probe) && pathLen == 0 {
+			pathLen = i + 1
+		}
+		mtr.Reached = mtr.Reached || probe.Reached
+		lastProbe = probe
+	}
+	switch {
+	case pathLen != 0:
+		// 到达目标或者收到目标不可达的那一跳，路径变短或者变长都以这一轮为准
+		mtr.PathLen = pathLen
+	case probeLen == t.paramInput.TraceMaxHops:
+		// 探测到最大跳数也没有到达目标
+		mtr.PathLen = probeLen
+	case lastProbe.Router != "":
+		// 原来的最后一跳回复了TTL超时，不再是目标，路径变长了
+		mtr.PathLen = t.paramInput.TraceMaxHops
+	}
+}
+
+// taskScheduleMtr 持续逐跳探测，每一轮所有目标探测完成后刷新表格，或者每个目标输出一行json
+func taskScheduleMtr(paramInput ParamInput, ctx context.Context, t *tracer, concurrencyTask *TaskList) {
+	// 每个goroutine负责的目标，统计跨轮次累计
+	var mtrList []*TraceMtr
+	routineMtrList := make([][]*TraceMtr, len(concurrencyTask.RoutineTaskList))
+	for i, list := range concurrencyTask.RoutineTaskList {
+		for _, item := range list.TaskItemList {
+			mtr := t.newTraceMtr(*item)
+			routineMtrList[i] = append(routineMtrList[i], mtr)
+			mtrList = append(mtrList, mtr)
+		}
+	}
+	sort.SliceStable(mtrList, func(i, j int) bool {
+		return mtrList[i].Target < mtrList[j].Target
+	})
+	round := 0
+	for {
+		round++
+		sTime := time.Now()
+		var wg sync.WaitGroup
+		for _, list := range routineMtrList {
+			wg.Add(1)
+			go func(list []*TraceMtr) {
+				defer wg.Done()
+				for _, mtr := range list {
+					select {
+					case <-ctx.Done():
+						return
+					default:
+					}
+					t.mtrRound(mtr, round)
+				}
+			}(list)
+		}
+		wg.Wait()
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		//至少停顿1秒
+		duration := time.Since(sTime)
+		if duration < time.Second {
+			time.Sleep(time.Second - duration)
+		}
+		if paramInput.ShowMode == utils.ShowModeJson || paramInput.ShowMode == utils.ShowModeNdjson {
+			// 每一轮每个目标输出一行json
+			for _, mtr := range mtrList {
+				printJson(newJsonMtr(mtr, concurrencyTask.TaskId, round, paramInput))
+			}
+		} else {
+			showMtrTable(mtrList, round, paramInput)
+		}
+	}
+}
+
+// showMtrTable 刷新持续逐跳探测的表格，每个目标一个表格，有丢包的跳标红
+func showMtrTable(mtrList []*TraceMtr, round int, paramInput ParamInput) {
+	red := color.New(color.FgRed).SprintFunc()
+	// 清除终端
+	print("\033[H\033[2J") // 可能不适用于所有终端
+	formattedTime := time.Now().Format("2006-01-02 15:04:05")
+	for _, mtr := range mtrList {
+		target := mtr.Target
+		if mtr.Port != 0 {
+			target = fmt.Sprintf("%s:%d", mtr.Target, mtr.Port)
+		}
+		if mtr.Error != "" {
+			fmt.Printf("traceroute %s %s，目标地址出错：%s\n\n", target, paramInput.TraceProto, mtr.Error)
+			continue
+		}
+		title := fmt.Sprintf("第%d轮 %s traceroute %s (%s) %s", round, formattedTime, target, mtr.Ip, paramInput.TraceProto)
+		if !mtr.Reached {
+			title += "，没有到达目标"
+		}
+		fmt.Println(title)
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"跳", "地址", "发包数", "丢包率", "最近(ms)", "平均(ms)", "最好(ms)", "最差(ms)", "标准差(ms)", "失败原因"})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		for _, stat := range mtr.HopStatList[:mtr.PathLen] {
+			routers := "*"
+			if len(stat.RouterMap) > 0 {
+				routers = strings.Join(stat.Routers(), ",")
+			}
+			loss := fmt.Sprintf("%.2f%%", stat.LossPercent())
+			if stat.ReceivedNumber < stat.SentNumber {
+				loss = red(loss)
+			}
+			rttList := []string{"-", "-", "-", "-", "-"}
+			if stat.ReceivedNumber > 0 {
+				rttList = []string{durationMs(stat.Last), durationMs(stat.Avg()), durationMs(stat.Best), durationMs(stat.Worst), durationMs(stat.Stdev())}
+			}
+			line := append([]string{strconv.Itoa(stat.Ttl), routers, strconv.Itoa(stat.SentNumber), loss}, rttList...)
+			table.Append(append(line, FailReasonString(stat.FailReasonMap)))
+		}
+		table.Render()
+		// 日志记录最后一跳，即到目标的丢包率
+		last := mtr.HopStatList[mtr.PathLen-1]
+		utils.Log.Infoln(fmt.Sprintf("第%d轮，目标 %s，协议 %s，跳数 %d，最后一跳丢包率 %.2f%%，平均时延 %sms", round, target, paramInput.TraceProto, mtr.PathLen, last.LossPercent(), durationMs(last.Avg())))
+		fmt.Println()
+	}
+}
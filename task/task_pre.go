@@ -6,11 +6,17 @@ import (
 	"github.com/asaskevich/govalidator"
 	"github.com/bwmarrin/snowflake"
 	mapset "github.com/deckarep/golang-set"
+	"go_ping/ping"
 	"go_ping/utils"
+	"math/rand"
+	"net"
 	"os"
+	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 /*
@@ -20,8 +26,17 @@ GenTaskList 根据参数生成tcp总列表
 */
 func GenTaskList(paramInput ParamInput, port int) *[]TaskItem {
 	totalTaskList := []TaskItem{}
-	// ip 网段 域名
-	if utils.ValidateIP(paramInput.DstTarget) { // ip
+	// ip 网段 域名 url
+	if paramInput.PingType == utils.PingTypeHTTP && utils.ValidateHttpUrl(paramInput.DstTarget) { // http打流的url，原样使用
+		taskItem := TaskItem{
+			DstTarget: paramInput.DstTarget,
+			DstPort:   utils.HttpUrlPort(paramInput.DstTarget),
+			SrcIp:     paramInput.SrcIp,
+			Timeout:   paramInput.Timeout,
+			PingType:  paramInput.PingType,
+		}
+		totalTaskList = append(totalTaskList, taskItem)
+	} else if utils.ValidateIP(paramInput.DstTarget) { // ip
 		taskItem := TaskItem{
 			DstTarget: paramInput.DstTarget,
 			DstPort:   port,
@@ -73,10 +88,13 @@ func GenTaskList(paramInput ParamInput, port int) *[]TaskItem {
 func GenTaskListBySingleTarget(paramInput ParamInput) *[]TaskItem {
 	// 生成任务列表
 	totalTaskList := GenTaskList(paramInput, paramInput.DstPort)
-	// http打流需要自动加上http头
+	// http打流需要自动加上http头，并加上命令行指定的选项
 	if paramInput.PingType == utils.PingTypeHTTP {
+		httpOption := genHttpOption(paramInput, nil)
 		for i := 0; i < len(*totalTaskList); i++ {
-			(*totalTaskList)[i].DstTarget = fmt.Sprintf("http://%s:%d", (*totalTaskList)[i].DstTarget, (*totalTaskList)[i].DstPort)
+			(*totalTaskList)[i].DstTarget = genHttpUrl((*totalTaskList)[i], paramInput.HttpScheme)
+			(*totalTaskList)[i].HttpOption = httpOption
+			(*totalTaskList)[i].HttpKey = (*totalTaskList)[i].DstTarget
 		}
 	}
 	// 返回指针
@@ -90,6 +108,7 @@ GenTaskListByFile 通过文件生成总列表
 1列 ： 如果是严格模式，tcp/icmp/http的行为，如果宽松模式，tcp/icmp/http的行为
 2列 ： 如果是严格模式，tcp/icmp/http的行为，如果宽松模式，tcp/icmp/http的行为
 多列 ： 如果是严格模式 则报错，宽松模式则略过
+http打流的行，目标后面可以跟key=value格式的选项，不计入列数，比如：https://a.com/healthz status=200 body=ok
 */
 func GenTaskListByFile(paramInput ParamInput) *[]TaskItem {
 	// 生成总任务列表
@@ -112,11 +131,25 @@ func GenTaskListByFile(paramInput ParamInput) *[]TaskItem {
 			continue
 		}
 		fields := strings.Fields(line)
+		// http打流的选项
+		var httpOption *ping.HttpOption
+		var optionFields []string
+		if paramInput.PingType == utils.PingTypeHTTP {
+			fields, optionFields = splitOptionFields(fields)
+			httpOption = genHttpOption(paramInput, optionFields)
+		}
 		fieldsLength := len(fields)
 		switch fieldsLength {
 		case 1:
+			// http打流的url，不需要端口
+			if paramInput.PingType == utils.PingTypeHTTP && utils.ValidateHttpUrl(fields[0]) {
+				paramInput.DstTarget = fields[0]
+				taskList = GenTaskList(paramInput, paramInput.DstPort)
+				break
+			}
+			// dns、tls有默认端口，可以只有一列
 			if !paramInput.DstFileLoose {
-				if paramInput.PingType == utils.PingTypeTCP || paramInput.PingType == utils.PingTypeHTTP {
+				if paramInput.PingType == utils.PingTypeTCP || paramInput.PingType == utils.PingTypeHTTP || paramInput.PingType == utils.PingTypeUDP || paramInput.PingType == utils.PingTypeTcpSyn || isTraceTcp(paramInput) {
 					fmt.Println("文件格式不正确，行号：", i+1)
 					os.Exit(0)
 				}
@@ -140,7 +173,7 @@ func GenTaskListByFile(paramInput ParamInput) *[]TaskItem {
 			taskList = GenTaskList(paramInput, paramInput.DstPort)
 		case 2:
 			if !paramInput.DstFileLoose {
-				if paramInput.PingType == utils.PingTypeICMP {
+				if paramInput.PingType == utils.PingTypeICMP || (paramInput.PingType == utils.PingTypeTrace && !isTraceTcp(paramInput)) {
 					fmt.Println("文件格式不正确，行号：", i+1)
 					os.Exit(0)
 				}
@@ -179,10 +212,16 @@ func GenTaskListByFile(paramInput ParamInput) *[]TaskItem {
 		// 汇总
 		for j := 0; j < len(*taskList); j++ {
 			if paramInput.PingType == utils.PingTypeHTTP {
-				(*taskList)[j].DstTarget = fmt.Sprintf("http://%s:%d", (*taskList)[j].DstTarget, (*taskList)[j].DstPort)
+				(*taskList)[j].DstTarget = genHttpUrl((*taskList)[j], paramInput.HttpScheme)
+				(*taskList)[j].HttpOption = httpOption
+				(*taskList)[j].HttpKey = genHttpKey((*taskList)[j].DstTarget, optionFields)
 			}
 			keyId := (*taskList)[j].DstTarget
-			if paramInput.PingType == utils.PingTypeTCP {
+			if paramInput.PingType == utils.PingTypeHTTP {
+				// 同一个url的多行指定了不同的选项时都保留，分开统计
+				keyId = (*taskList)[j].HttpKey
+			}
+			if paramInput.PingType == utils.PingTypeTCP || paramInput.PingType == utils.PingTypeUDP || paramInput.PingType == utils.PingTypeDNS || paramInput.PingType == utils.PingTypeTLS || paramInput.PingType == utils.PingTypeTcpSyn || isTraceTcp(paramInput) {
 				keyId = fmt.Sprintf("%s|%d", (*taskList)[j].DstTarget, (*taskList)[j].DstPort)
 			}
 			if uniqueKeySet.Contains(keyId) {
@@ -195,6 +234,131 @@ func GenTaskListByFile(paramInput ParamInput) *[]TaskItem {
 	return &totalTaskList
 }
 
+// isTraceTcp 是否是tcp逐跳探测，和tcp打流一样需要端口
+func isTraceTcp(paramInput ParamInput) bool {
+	return paramInput.PingType == utils.PingTypeTrace && paramInput.TraceProto == utils.TraceProtoTcp
+}
+
+// splitOptionFields 把一行分为目标字段和选项字段，第一列之后包含=的为选项字段
+func splitOptionFields(fields []string) ([]string, []string) {
+	var targetFields []string
+	var optionFields []string
+	for i, field := range fields {
+		if i > 0 && strings.Contains(field, "=") {
+			optionFields = append(optionFields, field)
+		} else {
+			targetFields = append(targetFields, field)
+		}
+	}
+	return targetFields, optionFields
+}
+
+// genHttpKey 生成http打流统计的key，文件的行指定了选项时在url后面加上排序后的选项，选项顺序不同的行视为同一个目标
+func genHttpKey(url string, optionFields []string) string {
+	if len(optionFields) == 0 {
+		return url
+	}
+	sortedFields := append([]string{}, optionFields...)
+	sort.Strings(sortedFields)
+	return url + " " + strings.Join(sortedFields, " ")
+}
+
+// genHttpOption 生成http打流的选项，先使用命令行指定的选项，文件的行指定了选项时再覆盖，选项格式错误直接退出
+func genHttpOption(paramInput ParamInput, optionFields []string) *ping.HttpOption {
+	httpOption := &ping.HttpOption{}
+	paramOptionMap := map[string]string{
+		"method":            paramInput.HttpMethod,
+		"request.header":    paramInput.HttpRequestHeader,
+		"request.body.file": paramInput.HttpRequestBodyFile,
+		"host":              paramInput.HttpHost,
+		"sni":               paramInput.TlsSni,
+		"redirect":          paramInput.HttpRedirect,
+		"redirect.max":      strconv.Itoa(paramInput.HttpRedirectMax),
+		"status":            paramInput.HttpStatus,
+		"body":              paramInput.HttpBody,
+		"body.regex":        paramInput.HttpBodyRegex,
+		"header":            paramInput.HttpHeader,
+		"body.max":          paramInput.HttpBodyMax,
+	}
+	for _, key := range ping.HttpOptionKeyList {
+		value := paramOptionMap[key]
+		if value == "" {
+			continue
+		}
+		if err := httpOption.Set(key, value); err != nil {
+			fmt.Println(fmt.Sprintf("参数http.%s格式错误：%v", key, err))
+			os.Exit(0)
+		}
+	}
+	if len(optionFields) == 0 {
+		return httpOption
+	}
+	lineOption := httpOption.Clone()
+	// 文件的行指定了请求头、响应头时，覆盖命令行指定的请求头、响应头
+	lineHeaderSet := false
+	lineRequestHeaderSet := false
+	for _, field := range optionFields {
+		key, value, _ := strings.Cut(field, "=")
+		if !utils.ContainsString(ping.HttpOptionKeyList, key) {
+			fmt.Println(fmt.Sprintf("文件格式不正确，不支持的选项：%s，支持的选项：%s", field, strings.Join(ping.HttpOptionKeyList, ",")))
+			os.Exit(0)
+		}
+		if key == "header" && !lineHeaderSet {
+			lineOption.Headers = nil
+			lineHeaderSet = true
+		}
+		if key == "request.header" && !lineRequestHeaderSet {
+			lineOption.RequestHeaders = nil
+			lineRequestHeaderSet = true
+		}
+		if err := lineOption.Set(key, value); err != nil {
+			fmt.Println(fmt.Sprintf("文件格式不正确，选项：%s，错误：%v", field, err))
+			os.Exit(0)
+		}
+	}
+	return lineOption
+}
+
+// GenIcmpOption 生成icmp打流的发包选项，参数已经校验过
+func GenIcmpOption(paramInput ParamInput) *ping.IcmpOption {
+	pattern, _ := utils.ParsePayload(paramInput.IcmpPattern)
+	return &ping.IcmpOption{
+		Size:    paramInput.IcmpSize,
+		Pattern: pattern,
+		Ttl:     paramInput.IcmpTtl,
+		Df:      paramInput.IcmpDf,
+		Tos:     paramInput.IcmpTos,
+	}
+}
+
+// genTcpOption 生成tcp打流的选项，没有指定任何tcp选项时返回nil，参数已经校验过
+func genTcpOption(paramInput ParamInput) *ping.TcpOption {
+	if paramInput.TcpSend == "" && paramInput.TcpExpect == "" && paramInput.TcpExpectRegex == "" && !paramInput.TcpCloseRst {
+		return nil
+	}
+	tcpOption := &ping.TcpOption{
+		ReadTimeout: time.Duration(paramInput.TcpReadTimeout) * time.Millisecond,
+		CloseRst:    paramInput.TcpCloseRst,
+	}
+	tcpOption.Send, _ = utils.ParsePayload(paramInput.TcpSend)
+	tcpOption.Expect, _ = utils.ParsePayload(paramInput.TcpExpect)
+	if paramInput.TcpExpectRegex != "" {
+		tcpOption.ExpectRegex = regexp.MustCompile(paramInput.TcpExpectRegex)
+	}
+	return tcpOption
+}
+
+// genHttpUrl http打流的目标，已经是url的原样返回，否则根据协议、目标和端口拼接成url
+func genHttpUrl(taskItem TaskItem, scheme string) string {
+	if utils.ValidateHttpUrl(taskItem.DstTarget) {
+		return taskItem.DstTarget
+	}
+	if scheme == "" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(taskItem.DstTarget, strconv.Itoa(taskItem.DstPort)))
+}
+
 // GenTotalTaskList 将任务复制成客户指定的打流次数
 func GenTotalTaskList(taskList *[]TaskItem, paramInput ParamInput) *[]TaskItem {
 	// icmp发包间隔
@@ -212,22 +376,32 @@ func GenTotalTaskList(taskList *[]TaskItem, paramInput ParamInput) *[]TaskItem {
 		number = 1
 	}
 	k := 0
+	// icmp的ID基数每个进程随机生成
+	icmpIdBase := rand.Intn(utils.MaxIcmpNum + 1)
 	for i := 0; i < number; i++ {
 		for j := 0; j < totalTaskLength; j++ {
 			taskItem := TaskItem{
-				DstTarget: (*taskList)[j].DstTarget,
-				DstPort:   (*taskList)[j].DstPort,
-				SrcIp:     (*taskList)[j].SrcIp,
-				Timeout:   (*taskList)[j].Timeout,
-				PingType:  (*taskList)[j].PingType,
+				DstTarget:  (*taskList)[j].DstTarget,
+				DstPort:    (*taskList)[j].DstPort,
+				SrcIp:      (*taskList)[j].SrcIp,
+				Timeout:    (*taskList)[j].Timeout,
+				PingType:   (*taskList)[j].PingType,
+				HttpOption: (*taskList)[j].HttpOption,
+				HttpKey:    (*taskList)[j].HttpKey,
 			}
 			if (*taskList)[j].PingType == utils.PingTypeICMP {
-				icmpId, icmpSeq := utils.GenIcmpIdAndSeq(k)
+				icmpId, icmpSeq := utils.GenIcmpIdAndSeq(icmpIdBase, k)
 				taskItem.IcmpId = icmpId
 				taskItem.IcmpSeq = icmpSeq
 				taskItem.IcmpSendInterval = icmpSendPkgInterval
 				k++
 			}
+			if (*taskList)[j].PingType == utils.PingTypeTcpSyn {
+				taskItem.TcpSynSrcPort = utils.TcpSynSrcPortMin + k%utils.TcpSynSrcPortNum
+				taskItem.TcpSynSeq = rand.Uint32()
+				taskItem.IcmpSendInterval = icmpSendPkgInterval
+				k++
+			}
 			totalTaskList = append(totalTaskList, taskItem)
 		}
 	}
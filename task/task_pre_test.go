@@ -0,0 +1,38 @@
+package task
+
+import (
+	"go_ping/utils"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGenTaskListByFileHttpKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string // 每个任务的统计key
+	}{
+		{"没有选项的重复行只保留一个", "http://10.0.0.1\nhttp://10.0.0.1\n", []string{"http://10.0.0.1"}},
+		{"同一个url不同的host都保留", "10.0.0.1 80 host=a.com\n10.0.0.1 80 host=b.com\n10.0.0.1 80\n", []string{"http://10.0.0.1:80 host=a.com", "http://10.0.0.1:80 host=b.com", "http://10.0.0.1:80"}},
+		{"不同的sni和method都保留", "https://a.com/ sni=a.com\nhttps://a.com/ method=POST\n", []string{"https://a.com/ sni=a.com", "https://a.com/ method=POST"}},
+		{"选项顺序不同视为同一个目标", "http://10.0.0.1 host=a.com method=HEAD\nhttp://10.0.0.1 method=HEAD host=a.com\n", []string{"http://10.0.0.1 host=a.com method=HEAD"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dstFile := filepath.Join(t.TempDir(), "targets.txt")
+			if err := os.WriteFile(dstFile, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			paramInput := ParamInput{PingType: utils.PingTypeHTTP, DstFile: dstFile, HttpScheme: "http", HttpMethod: "GET", HttpRedirect: "follow", HttpRedirectMax: 10, Timeout: 1000}
+			var got []string
+			for _, item := range *GenTaskListByFile(paramInput) {
+				got = append(got, item.HttpKey)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GenTaskListByFile() keys = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,9 +4,10 @@ package task
 import (
 	"context"
 	"fmt"
-	"github.com/fatih/color"
+	"go_ping/ping"
 	"go_ping/utils"
-	"golang.org/x/net/icmp"
+	"math/rand"
+	"net"
 	"os"
 	"sync"
 	"time"
@@ -30,6 +31,7 @@ func TaskSchedule(paramInput ParamInput, wg *sync.WaitGroup, fr *FailRate, ctx c
 	// 分配到多个goroutine中
 	concurrencyTask := GenConcurrencyTaskList(taskList, paramInput.Concurrency)
 	taskId := concurrencyTask.TaskId
+	fl.TaskId = taskId
 	for _, list := range concurrencyTask.RoutineTaskList {
 		if len(list.TaskItemList) == 0 {
 			fmt.Println(utils.NoTaskError)
@@ -37,35 +39,79 @@ func TaskSchedule(paramInput ParamInput, wg *sync.WaitGroup, fr *FailRate, ctx c
 		}
 		break
 	}
+	// http长连接模式，每个协程一个，持续打流时跨轮次复用连接
+	httpTransportCacheList := newHttpTransportCacheList(paramInput, len(concurrencyTask.RoutineTaskList))
 	// 持续打流
 	if paramInput.Number == 0 {
+		defer closeHttpTransportCacheList(httpTransportCacheList)
 		instanceName := getInstanceName(paramInput)
 		table := NewForeverTable()
+		round := 0
 		for {
+			round++
 			sTime := time.Now()
-			for _, list := range concurrencyTask.RoutineTaskList {
+			for i, list := range concurrencyTask.RoutineTaskList {
 				wg.Add(1)
-				go TaskLoop(list, wg, fr, taskId, paramInput, ctx)
+				go TaskLoop(list, wg, fr, taskId, paramInput, ctx, httpTransportCacheList[i])
 			}
 			wg.Wait()
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
 			//至少停顿1秒
 			eTime := time.Now()
 			duration := eTime.Sub(sTime)
 			if duration < time.Second {
 				time.Sleep(time.Second - duration)
 			}
-			// 画表
-			ShowTableForever(fr, table, instanceName, paramInput.PingType)
+			if paramInput.ShowMode == utils.ShowModeJson {
+				// 每一轮输出一行json
+				ShowJsonForever(fr, taskId, round, paramInput, fl.TaskNumber, sTime)
+			} else if paramInput.ShowMode == utils.ShowModeNdjson {
+				// 探测事件已经逐行输出，只需统计并清空本轮数据
+				fr.Statistic()
+				fr.Clean()
+			} else {
+				// 画表
+				ShowTableForever(fr, table, instanceName, paramInput)
+			}
 		}
 	} else { //指定打包次数
-		for _, list := range concurrencyTask.RoutineTaskList {
+		for i, list := range concurrencyTask.RoutineTaskList {
 			wg.Add(1)
-			go TaskLoop(list, wg, fr, taskId, paramInput, ctx)
+			go func(list *RoutineTaskItem, httpTransportCache *ping.HttpTransportCache) {
+				if httpTransportCache != nil {
+					defer httpTransportCache.Close()
+				}
+				TaskLoop(list, wg, fr, taskId, paramInput, ctx, httpTransportCache)
+			}(list, httpTransportCacheList[i])
+		}
+	}
+}
+
+// newHttpTransportCacheList http长连接模式下为每个协程创建复用的连接，其他情况都为nil
+func newHttpTransportCacheList(paramInput ParamInput, routineNumber int) []*ping.HttpTransportCache {
+	httpTransportCacheList := make([]*ping.HttpTransportCache, routineNumber)
+	if paramInput.PingType == utils.PingTypeHTTP && paramInput.HttpConn == utils.HttpConnKeepAlive {
+		for i := range httpTransportCacheList {
+			httpTransportCacheList[i] = ping.NewHttpTransportCache()
 		}
 	}
+	return httpTransportCacheList
 }
 
-func TaskScheduleICMP(paramInput ParamInput, wg *sync.WaitGroup, fr *FailRate, ctx context.Context, handle *icmp.PacketConn, handleV6 *icmp.PacketConn, fl *FileTaskItemNumber) {
+// closeHttpTransportCacheList 调度结束时关闭所有长连接
+func closeHttpTransportCacheList(httpTransportCacheList []*ping.HttpTransportCache) {
+	for _, httpTransportCache := range httpTransportCacheList {
+		if httpTransportCache != nil {
+			httpTransportCache.Close()
+		}
+	}
+}
+
+func TaskScheduleICMP(paramInput ParamInput, wg *sync.WaitGroup, fr *FailRate, ctx context.Context, handle net.PacketConn, handleV6 net.PacketConn, fl *FileTaskItemNumber) {
 	taskList := new([]TaskItem)
 	// 读取文件
 	if paramInput.DstFile != "" { // 读取文件
@@ -83,6 +129,7 @@ func TaskScheduleICMP(paramInput ParamInput, wg *sync.WaitGroup, fr *FailRate, c
 	// 分配到多个goroutine中
 	concurrencyTask := GenConcurrencyTaskList(taskList, paramInput.Concurrency)
 	taskId := concurrencyTask.TaskId
+	fl.TaskId = taskId
 	for _, list := range concurrencyTask.RoutineTaskList {
 		if len(list.TaskItemList) == 0 {
 			fmt.Println(utils.NoTaskError)
@@ -94,9 +141,11 @@ func TaskScheduleICMP(paramInput ParamInput, wg *sync.WaitGroup, fr *FailRate, c
 	if paramInput.Number == 0 {
 		instanceName := getInstanceName(paramInput)
 		table := NewForeverTable()
+		round := 0
 		for {
+			round++
 			sTime := time.Now()
-			icmpSendReceivePkg(paramInput, wg, fr, ctx, handle, handleV6, taskList, concurrencyTask, taskId)
+			icmpSendReceivePkg(paramInput, wg, fr, ctx, handle, handleV6, taskList, concurrencyTask, taskId, round)
 			wg.Wait()
 			//至少停顿1秒
 			eTime := time.Now()
@@ -104,22 +153,37 @@ func TaskScheduleICMP(paramInput ParamInput, wg *sync.WaitGroup, fr *FailRate, c
 			if duration < time.Second {
 				time.Sleep(time.Second - duration)
 			}
-			// 画表
-			ShowTableForever(fr, table, instanceName, paramInput.PingType)
+			if paramInput.ShowMode == utils.ShowModeJson {
+				// 每一轮输出一行json
+				ShowJsonForever(fr, taskId, round, paramInput, fl.TaskNumber, sTime)
+			} else if paramInput.ShowMode == utils.ShowModeNdjson {
+				// 探测事件已经逐行输出，只需统计并清空本轮数据
+				fr.Statistic()
+				fr.Clean()
+			} else {
+				// 画表
+				ShowTableForever(fr, table, instanceName, paramInput)
+			}
 		}
 	} else { //指定打包次数
-		icmpSendReceivePkg(paramInput, wg, fr, ctx, handle, handleV6, taskList, concurrencyTask, taskId)
+		icmpSendReceivePkg(paramInput, wg, fr, ctx, handle, handleV6, taskList, concurrencyTask, taskId, 0)
 	}
 }
 
-// icmp一轮发包和收包程序
-func icmpSendReceivePkg(paramInput ParamInput, wg *sync.WaitGroup, fr *FailRate, ctx context.Context, handle *icmp.PacketConn, handleV6 *icmp.PacketConn, taskList *[]TaskItem, concurrencyTask *TaskList, taskId string) {
-	// 颜色渲染字体
-	red := color.New(color.FgRed).SprintFunc()
+// icmp一轮发包和收包程序，round为持续打流的轮次，指定打流次数时为0，发包内容里带上轮次，区分之前轮次迟到的回复
+func icmpSendReceivePkg(paramInput ParamInput, wg *sync.WaitGroup, fr *FailRate, ctx context.Context, handle net.PacketConn, handleV6 net.PacketConn, taskList *[]TaskItem, concurrencyTask *TaskList, taskId string, round int) {
 	// 获取id|seq的集合，判断是本进程发出的icmp包
 	icmpIdSeqIpMap := sync.Map{}
-	for i := 0; i < len(*taskList); i++ {
-		icmpIdSeqIpMap.Store(fmt.Sprintf("%d|%d", (*taskList)[i].IcmpId, (*taskList)[i].IcmpSeq), (*taskList)[i].DstTarget)
+	// id|seq对应的目标，只读，用于找到之前轮次迟到的回复是哪个目标的
+	icmpTargetMap := make(map[string]string)
+	for _, list := range concurrencyTask.RoutineTaskList {
+		for _, item := range list.TaskItemList {
+			icmpTargetMap[fmt.Sprintf("%d|%d", item.IcmpId, item.IcmpSeq)] = item.DstTarget
+			icmpIdSeqIpMap.Store(fmt.Sprintf("%d|%d", item.IcmpId, item.IcmpSeq), &icmpPendingItem{
+				RoutineId: list.RoutineId,
+				Item:      item,
+			})
+		}
 	}
 	// 发包
 	// 发包完成后通知收包
@@ -129,13 +193,13 @@ func icmpSendReceivePkg(paramInput ParamInput, wg *sync.WaitGroup, fr *FailRate,
 	// 收包放前面
 	wg.Add(2)
 	wgReceive.Add(2)
-	go IcmpPingReceive(paramInput, handle, wg, fr, ctx, doneSend, &icmpIdSeqIpMap, &wgReceive)
-	go IcmpPingReceiveV6(paramInput, handleV6, wg, fr, ctx, doneSend, &icmpIdSeqIpMap, &wgReceive)
+	go IcmpPingReceive(paramInput, handle, wg, fr, ctx, doneSend, &icmpIdSeqIpMap, icmpTargetMap, &wgReceive, taskId, round)
+	go IcmpPingReceiveV6(paramInput, handleV6, wg, fr, ctx, doneSend, &icmpIdSeqIpMap, icmpTargetMap, &wgReceive, taskId, round)
 	// 发包
 	for _, list := range concurrencyTask.RoutineTaskList {
 		wg.Add(1)
 		wgSend.Add(1)
-		go TaskLoopICMP(list, wg, ctx, handle, handleV6, &wgSend)
+		go TaskLoopICMP(list, wg, ctx, handle, handleV6, &wgSend, fr, &icmpIdSeqIpMap, taskId, paramInput, round)
 	}
 	// 启动一个 goroutine 来等待所有任务完成，然后发送通知
 	go func() {
@@ -150,19 +214,132 @@ func icmpSendReceivePkg(paramInput ParamInput, wg *sync.WaitGroup, fr *FailRate,
 		defer wg.Done()
 		wgReceive.Wait()
 		icmpIdSeqIpMap.Range(func(key, value interface{}) bool {
-			dstIp := value.(string)
-			//fmt.Println(fmt.Sprintf("error ip and key %s %s", dstIp, key))
-			successNum, failNum := fr.Increment(dstIp, false)
-			if paramInput.ShowMode == utils.ShowModeWaterfall && paramInput.Number != 0 {
-				sprintf := fmt.Sprintf("%s\t%s\t失败率%.2f%%\t失败%d\t总共%d", dstIp, red("fail"), float64(failNum)*100/float64(failNum+successNum), failNum, failNum+successNum)
-				fmt.Println(sprintf)
-			}
+			pendingItem := value.(*icmpPendingItem)
+			//fmt.Println(fmt.Sprintf("error ip and key %s %s", pendingItem.Item.DstTarget, key))
+			// 超时未收到回复
+			recordIcmpFail(paramInput, fr, taskId, pendingItem, ping.PingResult{Reason: ping.FailReasonTimeout})
 			// 如果回调返回true，则继续遍历，返回false则停止遍历
 			return true
 		})
 	}()
 }
 
+// TaskScheduleTcpSyn tcp-syn打流的任务调度，跟icmp一样收发分离
+func TaskScheduleTcpSyn(paramInput ParamInput, wg *sync.WaitGroup, fr *FailRate, ctx context.Context, handle *net.IPConn, handleV6 *net.IPConn, fl *FileTaskItemNumber) {
+	taskList := new([]TaskItem)
+	// 读取文件
+	if paramInput.DstFile != "" { // 读取文件
+		taskList = GenTaskListByFile(paramInput)
+	} else if paramInput.DstTarget != "" { // 单个目标
+		taskList = GenTaskListBySingleTarget(paramInput)
+	}
+	if len(*taskList) == 0 {
+		fmt.Println(utils.NoTaskError)
+		os.Exit(0)
+	}
+	// 根据-n参数进行任务复制
+	taskList = GenTotalTaskList(taskList, paramInput)
+	fl.TaskNumber = len(*taskList)
+	// 分配到多个goroutine中
+	concurrencyTask := GenConcurrencyTaskList(taskList, paramInput.Concurrency)
+	taskId := concurrencyTask.TaskId
+	fl.TaskId = taskId
+	for _, list := range concurrencyTask.RoutineTaskList {
+		if len(list.TaskItemList) == 0 {
+			fmt.Println(utils.NoTaskError)
+			os.Exit(0)
+		}
+		break
+	}
+	// 上一轮没有收到回复的源端口|序列号，本轮收到时计为迟到的回复
+	lateSeqMap := &sync.Map{}
+	// 持续打流
+	if paramInput.Number == 0 {
+		instanceName := getInstanceName(paramInput)
+		table := NewForeverTable()
+		round := 0
+		for {
+			round++
+			sTime := time.Now()
+			tcpSynSendReceivePkg(paramInput, wg, fr, ctx, handle, handleV6, concurrencyTask, taskId, lateSeqMap)
+			wg.Wait()
+			//至少停顿1秒
+			eTime := time.Now()
+			duration := eTime.Sub(sTime)
+			if duration < time.Second {
+				time.Sleep(time.Second - duration)
+			}
+			if paramInput.ShowMode == utils.ShowModeJson {
+				// 每一轮输出一行json
+				ShowJsonForever(fr, taskId, round, paramInput, fl.TaskNumber, sTime)
+			} else if paramInput.ShowMode == utils.ShowModeNdjson {
+				// 探测事件已经逐行输出，只需统计并清空本轮数据
+				fr.Statistic()
+				fr.Clean()
+			} else {
+				// 画表
+				ShowTableForever(fr, table, instanceName, paramInput)
+			}
+		}
+	} else { //指定打包次数
+		tcpSynSendReceivePkg(paramInput, wg, fr, ctx, handle, handleV6, concurrencyTask, taskId, lateSeqMap)
+	}
+}
+
+// tcp-syn一轮发包和收包程序，lateSeqMap为上一轮没有收到回复的源端口|序列号，本轮结束后换成本轮的
+func tcpSynSendReceivePkg(paramInput ParamInput, wg *sync.WaitGroup, fr *FailRate, ctx context.Context, handle *net.IPConn, handleV6 *net.IPConn, concurrencyTask *TaskList, taskId string, lateSeqMap *sync.Map) {
+	// 获取源端口|序列号的集合，判断是对本进程SYN的回复
+	portSeqMap := sync.Map{}
+	for _, list := range concurrencyTask.RoutineTaskList {
+		for _, item := range list.TaskItemList {
+			// 每一轮使用新的序列号，上一轮迟到的回复不会被当成本轮的
+			item.TcpSynSeq = rand.Uint32()
+			portSeqMap.Store(fmt.Sprintf("%d|%d", item.TcpSynSrcPort, item.TcpSynSeq), &tcpSynPendingItem{
+				RoutineId: list.RoutineId,
+				Item:      item,
+			})
+		}
+	}
+	// 发包完成后通知收包
+	var wgSend sync.WaitGroup
+	var wgReceive sync.WaitGroup
+	doneSend := make(chan struct{}) // 创建一个通道用于通知
+	// 收包放前面
+	wg.Add(2)
+	wgReceive.Add(2)
+	go TcpSynReceive(paramInput, handle, wg, fr, ctx, doneSend, &portSeqMap, lateSeqMap, &wgReceive, taskId)
+	go TcpSynReceive(paramInput, handleV6, wg, fr, ctx, doneSend, &portSeqMap, lateSeqMap, &wgReceive, taskId)
+	// 发包
+	for _, list := range concurrencyTask.RoutineTaskList {
+		wg.Add(1)
+		wgSend.Add(1)
+		go TaskLoopTcpSyn(list, wg, ctx, handle, handleV6, &wgSend, fr, &portSeqMap, taskId, paramInput)
+	}
+	// 启动一个 goroutine 来等待所有任务完成，然后发送通知
+	go func() {
+		wgSend.Wait()
+		time.Sleep(time.Duration(paramInput.Timeout) * time.Second)
+		close(doneSend) // 关闭通道用于通知所有等待的 goroutine
+	}()
+	// 处理未收到的包
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wgReceive.Wait()
+		lateSeqMap.Range(func(key, value interface{}) bool {
+			lateSeqMap.Delete(key)
+			return true
+		})
+		portSeqMap.Range(func(key, value interface{}) bool {
+			item := value.(*tcpSynPendingItem).Item
+			lateSeqMap.Store(key, fmt.Sprintf("%s|%d", item.DstTarget, item.DstPort))
+			// 超时没有收到SYN-ACK或者RST，被过滤
+			recordTcpSynResult(paramInput, fr, taskId, value.(*tcpSynPendingItem), ping.PingResult{Reason: ping.FailReasonFiltered})
+			return true
+		})
+	}()
+}
+
 func getInstanceName(paramInput ParamInput) string {
 	//获取实例名称
 	instanceName := ""
@@ -170,7 +347,7 @@ func getInstanceName(paramInput ParamInput) string {
 		instanceName = paramInput.DstFile
 		return instanceName
 	} else {
-		if paramInput.PingType == utils.PingTypeTCP {
+		if paramInput.PingType == utils.PingTypeTCP || paramInput.PingType == utils.PingTypeUDP || paramInput.PingType == utils.PingTypeDNS || paramInput.PingType == utils.PingTypeTLS || paramInput.PingType == utils.PingTypeTcpSyn {
 			instanceName = fmt.Sprintf("%s|%d", paramInput.DstTarget, paramInput.DstPort)
 		} else if paramInput.PingType == utils.PingTypeICMP || paramInput.PingType == utils.PingTypeHTTP {
 			instanceName = paramInput.DstTarget
@@ -0,0 +1,56 @@
+package task
+
+import (
+	"context"
+	"go_ping/utils"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHttpConnAcrossRounds(t *testing.T) {
+	tests := []struct {
+		name        string
+		httpConn    string
+		wantConnNum int32
+	}{
+		{"长连接跨轮次复用", utils.HttpConnKeepAlive, 1},
+		{"每次新建连接", utils.HttpConnNew, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var connNum int32
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("ok"))
+			}))
+			server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					atomic.AddInt32(&connNum, 1)
+				}
+			}
+			server.Start()
+			defer server.Close()
+			paramInput := ParamInput{PingType: utils.PingTypeHTTP, HttpConn: tt.httpConn, ShowMode: utils.ShowModeTable, Timeout: 1}
+			list := &RoutineTaskItem{RoutineId: 1, TaskItemList: []*TaskItem{{Id: 1, DstTarget: server.URL, HttpKey: server.URL, PingType: utils.PingTypeHTTP, Timeout: 1}}}
+			httpTransportCacheList := newHttpTransportCacheList(paramInput, 1)
+			defer closeHttpTransportCacheList(httpTransportCacheList)
+			fr := NewFailRate()
+			// 持续打流的两轮，每轮新起一个协程
+			for round := 0; round < 2; round++ {
+				var wg sync.WaitGroup
+				wg.Add(1)
+				go TaskLoop(list, &wg, fr, "1", paramInput, context.Background(), httpTransportCacheList[0])
+				wg.Wait()
+			}
+			if fr.SuccessNumber != 2 {
+				t.Fatalf("SuccessNumber = %d, want 2", fr.SuccessNumber)
+			}
+			if got := atomic.LoadInt32(&connNum); got != tt.wantConnNum {
+				t.Errorf("new connections = %d, want %d", got, tt.wantConnNum)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/fatih/color"
 	"github.com/olekukonko/tablewriter"
+	"go_ping/ping"
 	"go_ping/utils"
 	"os"
 	"sort"
@@ -45,34 +46,122 @@ func showTable(fr *FailRate, taskNum int, paramInput ParamInput) {
 	if totalNumFirstLine != 0 {
 		percentFirstLine = float64(fr.FailNumber) * 100 / float64(totalNumFirstLine)
 	}
-	totalLine := []string{"汇总", formattedTime, "所有实例", paramInput.PingType, strconv.Itoa(fr.FailNumber), strconv.Itoa(totalNumFirstLine), strconv.Itoa(taskNum), fmt.Sprintf("%.2f%%", percentFirstLine)}
+	totalRttStat := fr.rttStat()
+	totalLine := []string{"汇总", formattedTime, "所有实例", paramInput.PingType, strconv.Itoa(fr.FailNumber), strconv.Itoa(totalNumFirstLine), strconv.Itoa(taskNum), fmt.Sprintf("%.2f%%", percentFirstLine), FailReasonString(fr.failReasonMap()), totalRttStat.MinAvgMaxMdev(), totalRttStat.Percentiles()}
+	// http打流多展示一列各阶段的平均耗时
+	showHttpTiming := paramInput.PingType == utils.PingTypeHTTP
+	if showHttpTiming {
+		totalLine = append(totalLine, fr.httpTimingStat().String())
+	}
+	// tls打流多展示一列证书信息
+	showTls := paramInput.PingType == utils.PingTypeTLS
+	if showTls {
+		totalLine = append(totalLine, "-")
+	}
+	// icmp打流多展示一列重复、乱序、迟到的回复数
+	showReplyAnomaly := paramInput.PingType == utils.PingTypeICMP
+	if showReplyAnomaly {
+		totalLine = append(totalLine, ReplyAnomalyString(fr.DupNumber, fr.ReorderNumber, fr.LateNumber))
+	}
+	// icmp、tcp-syn打流多展示一列根据回复TTL估算的跳数
+	showHops := paramInput.PingType == utils.PingTypeICMP || paramInput.PingType == utils.PingTypeTcpSyn
+	if showHops {
+		totalLine = append(totalLine, "-")
+	}
+	// 每个目标一行：目标实例、已失败数、已发包数、失败占比、时延统计
+	for key, failRateItem := range fr.ResultMap {
+		totalNum := failRateItem.SuccessNumber + failRateItem.FailNumber
+		percent := 0.0
+		if totalNum != 0 {
+			percent = float64(failRateItem.FailNumber) * 100 / float64(totalNum)
+		}
+		data = append(data, []interface{}{key, strconv.Itoa(failRateItem.FailNumber), strconv.Itoa(totalNum), percent, failRateItem.RttAgg.Stat(), FailReasonString(failRateItem.FailReasonMap), failRateItem.HttpTimingAgg.Stat(), failRateItem.Tls, ReplyAnomalyString(failRateItem.DupNumber, failRateItem.ReorderNumber, failRateItem.LateNumber), HopsString(fr.HopMap, key)})
+	}
+	localFailHint := LocalFailHint(fr.LocalFailNumber)
+	lateForeignHint := LateForeignHint(fr.LateNumber, fr.ForeignNumber)
 	// 解锁
 	fr.mutex.Unlock()
 	// 创建表格
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"ID", "时间", "目标实例", "发包类型", "已失败数", "已发包数", "计划发包数", "失败占比"})
+	header := []string{"ID", "时间", "目标实例", "发包类型", "已失败数", "已发包数", "计划发包数", "失败占比", "失败原因", "时延min/avg/max/mdev(ms)", "时延p50/p90/p99(ms)"}
+	footerColors := []tablewriter.Colors{{}, {}, {}, {}, {tablewriter.FgRedColor}, {}, {}, {}, {}, {}, {}}
+	if showHttpTiming {
+		header = append(header, "阶段dns/connect/tls/ttfb/transfer(ms)")
+		footerColors = append(footerColors, tablewriter.Colors{})
+	}
+	if showTls {
+		header = append(header, "证书")
+		footerColors = append(footerColors, tablewriter.Colors{})
+	}
+	if showReplyAnomaly {
+		header = append(header, "重复/乱序/迟到")
+		footerColors = append(footerColors, tablewriter.Colors{})
+	}
+	if showHops {
+		header = append(header, "跳数")
+		footerColors = append(footerColors, tablewriter.Colors{})
+	}
+	table.SetHeader(header)
 	table.SetFooter(totalLine)
 	table.SetFooterAlignment(tablewriter.ALIGN_LEFT)
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
-	table.SetFooterColor(tablewriter.Colors{}, tablewriter.Colors{}, tablewriter.Colors{}, tablewriter.Colors{}, tablewriter.Colors{tablewriter.FgRedColor}, tablewriter.Colors{}, tablewriter.Colors{}, tablewriter.Colors{})
-	// data排序，假设我们按照每行的第4个元素（索引为3的整数）进行排序
+	table.SetFooterColor(footerColors...)
+	// data排序，按照每行的第4个元素（索引为3的失败占比）从高到低排序，失败占比相同的按目标实例排序
 	sort.Slice(data, func(i, j int) bool {
-		// 断言值为int类型
+		// 断言值为float64类型
 		valueI, _ := data[i][3].(float64)
 		valueJ, _ := data[j][3].(float64)
-		return valueI < valueJ
+		if valueI != valueJ {
+			return valueI > valueJ
+		}
+		keyI, _ := data[i][0].(string)
+		keyJ, _ := data[j][0].(string)
+		return keyI < keyJ
 	})
+	// 只展示失败占比最高的前N个目标
+	if paramInput.ShowTop > 0 && len(data) > paramInput.ShowTop {
+		data = data[:paramInput.ShowTop]
+	}
 	for i, v := range data {
 		// 转换为 string 切片
-		stringSlice := []string{strconv.Itoa(i + 1), formattedTime, fmt.Sprintf("%s", v[0]), paramInput.PingType, red(fmt.Sprintf("%s", v[1])), fmt.Sprintf("%s", v[2]), strconv.Itoa(paramInput.Number), fmt.Sprintf("%.2f%%", v[3])}
+		rttStat, _ := v[4].(RttStat)
+		stringSlice := []string{strconv.Itoa(i + 1), formattedTime, fmt.Sprintf("%s", v[0]), paramInput.PingType, red(fmt.Sprintf("%s", v[1])), fmt.Sprintf("%s", v[2]), strconv.Itoa(paramInput.Number), fmt.Sprintf("%.2f%%", v[3]), fmt.Sprintf("%s", v[5]), rttStat.MinAvgMaxMdev(), rttStat.Percentiles()}
+		if showHttpTiming {
+			httpTimingStat, _ := v[6].(HttpTimingStat)
+			stringSlice = append(stringSlice, httpTimingStat.String())
+		}
+		if showTls {
+			tlsResult, _ := v[7].(*ping.TlsResult)
+			tlsString := TlsString(tlsResult)
+			if tlsResult != nil && tlsResult.Expiring {
+				tlsString = red(tlsString)
+			}
+			stringSlice = append(stringSlice, tlsString)
+		}
+		if showReplyAnomaly {
+			stringSlice = append(stringSlice, fmt.Sprintf("%s", v[8]))
+		}
+		if showHops {
+			stringSlice = append(stringSlice, fmt.Sprintf("%s", v[9]))
+		}
 		table.Append(stringSlice)
 	}
 	// 渲染表格
 	table.Render()
+	if localFailHint != "" {
+		fmt.Println(red(localFailHint))
+	}
+	if lateForeignHint != "" {
+		fmt.Println(lateForeignHint)
+	}
+	if paramInput.IcmpEffective != nil {
+		fmt.Println("icmp发包选项", paramInput.IcmpEffective.String())
+	}
 }
 
 // ShowTableForever 持续打流是永远输出表格内容
-func ShowTableForever(fr *FailRate, tb *ForeverTable, instanceName string, pingType string) {
+func ShowTableForever(fr *FailRate, tb *ForeverTable, instanceName string, paramInput ParamInput) {
+	pingType := paramInput.PingType
 	// 颜色渲染字体
 	red := color.New(color.FgRed).SprintFunc()
 	green := color.New(color.FgGreen).SprintFunc()
@@ -80,26 +169,141 @@ func ShowTableForever(fr *FailRate, tb *ForeverTable, instanceName string, pingT
 	print("\033[H\033[2J") // 可能不适用于所有终端
 	// 创建表格
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"批次", "时间", "目标实例", "发包类型", "成功数", "失败数", "目标总数", "失败占比", "变化IP数", "变化IP"})
+	header := []string{"批次", "时间", "目标实例", "发包类型", "成功数", "失败数", "目标总数", "失败占比", "失败原因", "时延min/avg/max/mdev(ms)", "时延p50/p90/p99(ms)", "变化IP数", "变化IP"}
+	// http打流多展示一列各阶段的平均耗时
+	showHttpTiming := pingType == utils.PingTypeHTTP
+	if showHttpTiming {
+		header = append(header, "阶段dns/connect/tls/ttfb/transfer(ms)")
+	}
+	// icmp打流多展示一列重复、乱序、迟到的回复数
+	showReplyAnomaly := pingType == utils.PingTypeICMP
+	if showReplyAnomaly {
+		header = append(header, "重复/乱序/迟到")
+	}
+	// icmp、tcp-syn打流多展示一列跳数发生变化的目标，一般是路由变了
+	showHopChange := pingType == utils.PingTypeICMP || pingType == utils.PingTypeTcpSyn
+	if showHopChange {
+		header = append(header, "跳数变化")
+	}
+	table.SetHeader(header)
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
 	// 统计数据
 	fr.Statistic()
 	// 写数据
-	tb.AppendLine(fr.SuccessNumber, fr.FailNumber, fr.FromSuccessToFail, fr.FromFailToSuccess)
+	fr.mutex.Lock()
+	rttStat := fr.rttStat()
+	httpTimingStat := fr.httpTimingStat()
+	failReasonMap := fr.failReasonMap()
+	localFailHint := LocalFailHint(fr.LocalFailNumber)
+	lateForeignHint := LateForeignHint(fr.LateNumber, fr.ForeignNumber)
+	replyAnomaly := ReplyAnomalyString(fr.DupNumber, fr.ReorderNumber, fr.LateNumber)
+	hopChange := HopChangeString(fr.HopChangeList)
+	fr.mutex.Unlock()
+	tb.AppendLine(fr.SuccessNumber, fr.FailNumber, rttStat, httpTimingStat, replyAnomaly, hopChange, failReasonMap, fr.FromSuccessToFail, fr.FromFailToSuccess)
 	// 打印table
 	tb.mutex.Lock() // 加锁读数据
 	for i, v := range tb.ForeverTableList {
 		// 转换为 string 切片
-		stringSlice := []string{v.Id, v.TimeString, instanceName, pingType, green(v.SuccessNumber), red(v.FailNumber), v.TotalNumber, v.FailPercent, v.ChangeIpNumber, v.ChangeIpSet}
+		stringSlice := []string{v.Id, v.TimeString, instanceName, pingType, green(v.SuccessNumber), red(v.FailNumber), v.TotalNumber, v.FailPercent, v.FailReason, v.Rtt, v.RttPercentile, v.ChangeIpNumber, v.ChangeIpSet}
+		if showHttpTiming {
+			stringSlice = append(stringSlice, v.HttpTiming)
+		}
+		if showReplyAnomaly {
+			stringSlice = append(stringSlice, v.ReplyAnomaly)
+		}
+		if showHopChange {
+			stringSlice = append(stringSlice, red(v.HopChange))
+		}
 		table.Append(stringSlice)
 		// 日志记录最后一行
 		if i+1 == len(tb.ForeverTableList) {
-			utils.Log.Infoln(fmt.Sprintf("批次 %s，时间 %s，目标实例 %s，发包类型 %s，成功数 %s，失败数 %s，目标总数 %s，失败占比 %s，变化IP数 %s，变化IP %s", v.Id, v.TimeString, instanceName, pingType, v.SuccessNumber, v.FailNumber, v.TotalNumber, v.FailPercent, v.ChangeIpNumber, v.ChangeIpSet))
+			utils.Log.Infoln(fmt.Sprintf("批次 %s，时间 %s，目标实例 %s，发包类型 %s，成功数 %s，失败数 %s，目标总数 %s，失败占比 %s，失败原因 %s，时延 %s，分位 %s，变化IP数 %s，变化IP %s", v.Id, v.TimeString, instanceName, pingType, v.SuccessNumber, v.FailNumber, v.TotalNumber, v.FailPercent, v.FailReason, v.Rtt, v.RttPercentile, v.ChangeIpNumber, v.ChangeIpSet))
 		}
 	}
 	tb.mutex.Unlock() // 解锁
 	// 渲染表格
 	table.Render()
+	if localFailHint != "" {
+		fmt.Println(red(localFailHint))
+		utils.Log.Warnln(localFailHint)
+	}
+	if lateForeignHint != "" {
+		fmt.Println(lateForeignHint)
+		utils.Log.Infoln(lateForeignHint)
+	}
+	if paramInput.IcmpEffective != nil {
+		fmt.Println("icmp发包选项", paramInput.IcmpEffective.String())
+	}
 	// 清空数据
 	fr.Clean()
 }
+
+// ShowWaterfallSummary 瀑布展示时，所有任务完成后输出每个目标的统计
+func ShowWaterfallSummary(fr *FailRate, paramInput ParamInput) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+	keys := make([]string, 0, len(fr.ResultMap))
+	for key := range fr.ResultMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Println("--- 统计 ---")
+	for _, key := range keys {
+		failRateItem := fr.ResultMap[key]
+		totalNum := failRateItem.SuccessNumber + failRateItem.FailNumber
+		rttStat := failRateItem.RttAgg.Stat()
+		line := fmt.Sprintf("%s\t失败率%.2f%%\t失败%d\t总共%d\t失败原因 %s\t时延min/avg/max/mdev %s ms\tp50/p90/p99 %s ms", key, failPercent(failRateItem.FailNumber, totalNum), failRateItem.FailNumber, totalNum, FailReasonString(failRateItem.FailReasonMap), rttStat.MinAvgMaxMdev(), rttStat.Percentiles())
+		if failRateItem.HttpTimingAgg.Number > 0 {
+			line += fmt.Sprintf("\t阶段dns/connect/tls/ttfb/transfer %s ms", failRateItem.HttpTimingAgg.Stat().String())
+		}
+		if failRateItem.Tls != nil {
+			line += fmt.Sprintf("\t证书 %s", TlsString(failRateItem.Tls))
+		}
+		if paramInput.PingType == utils.PingTypeICMP {
+			line += fmt.Sprintf("\t重复%d\t乱序%d\t迟到%d", failRateItem.DupNumber, failRateItem.ReorderNumber, failRateItem.LateNumber)
+		}
+		if _, exists := fr.HopMap[key]; exists {
+			line += fmt.Sprintf("\t跳数%s", HopsString(fr.HopMap, key))
+		}
+		fmt.Println(line)
+	}
+	if localFailHint := LocalFailHint(fr.LocalFailNumber); localFailHint != "" {
+		fmt.Println(localFailHint)
+	}
+	if lateForeignHint := LateForeignHint(fr.LateNumber, fr.ForeignNumber); lateForeignHint != "" {
+		fmt.Println(lateForeignHint)
+	}
+	if paramInput.IcmpEffective != nil {
+		fmt.Println("icmp发包选项", paramInput.IcmpEffective.String())
+	}
+	showRedirectSummary(fr)
+}
+
+// ShowRedirectSummary 表格展示时，所有任务完成后输出http打流的跳转链
+func ShowRedirectSummary(fr *FailRate) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+	showRedirectSummary(fr)
+}
+
+// showRedirectSummary 输出每个目标每种跳转链的次数，没有跳转时不输出，调用方需要加锁
+func showRedirectSummary(fr *FailRate) {
+	redirectMap := make(map[string]int)
+	for _, failRateItem := range fr.ResultMap {
+		for chain, number := range failRateItem.RedirectMap {
+			redirectMap[chain] += number
+		}
+	}
+	if len(redirectMap) == 0 {
+		return
+	}
+	chains := make([]string, 0, len(redirectMap))
+	for chain := range redirectMap {
+		chains = append(chains, chain)
+	}
+	sort.Strings(chains)
+	fmt.Println("--- 跳转 ---")
+	for _, chain := range chains {
+		fmt.Println(fmt.Sprintf("%s\t%d次", chain, redirectMap[chain]))
+	}
+}
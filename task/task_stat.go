@@ -0,0 +1,204 @@
+// Package task 本包提供时延的统计
+package task
+
+import (
+	"fmt"
+	"go_ping/ping"
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// RttStat 一组时延的统计
+type RttStat struct {
+	Number int           // 时延个数，即成功数
+	Min    time.Duration // 最小值
+	Avg    time.Duration // 平均值
+	Max    time.Duration // 最大值
+	Mdev   time.Duration // 标准差，同ping的mdev
+	P50    time.Duration // 50分位
+	P90    time.Duration // 90分位
+	P99    time.Duration // 99分位
+}
+
+// rttBucketSubBits 时延直方图每个2的幂区间分为2^rttBucketSubBits个桶，分位数的相对误差不超过1/64
+const rttBucketSubBits = 6
+
+// rttBucket 直方图的一个桶，记录落在桶里的时延个数和总和，分位数取桶里的平均值，桶里的时延都相同时是精确的
+type rttBucket struct {
+	Number int
+	Sum    time.Duration
+}
+
+// RttAgg 时延的累计统计，只保存个数、最小值、最大值、总和、平方和以及按微秒分桶的直方图，内存不随探测次数增长
+type RttAgg struct {
+	Number    int                // 时延个数
+	Min       time.Duration      // 最小值
+	Max       time.Duration      // 最大值
+	Sum       float64            // 总和，单位纳秒
+	SumSquare float64            // 平方和，用于计算标准差
+	Buckets   map[int]*rttBucket // 用到的桶，下标见rttBucketIndex，最多几千个
+}
+
+// Add 记录一个时延
+func (a *RttAgg) Add(rtt time.Duration) {
+	if a.Number == 0 || rtt < a.Min {
+		a.Min = rtt
+	}
+	if a.Number == 0 || rtt > a.Max {
+		a.Max = rtt
+	}
+	a.Number++
+	a.Sum += float64(rtt)
+	a.SumSquare += float64(rtt) * float64(rtt)
+	if a.Buckets == nil {
+		a.Buckets = make(map[int]*rttBucket)
+	}
+	index := rttBucketIndex(rtt)
+	bucket, exists := a.Buckets[index]
+	if !exists {
+		bucket = &rttBucket{}
+		a.Buckets[index] = bucket
+	}
+	bucket.Number++
+	bucket.Sum += rtt
+}
+
+// Stat 统计时延
+func (a RttAgg) Stat() RttStat {
+	stat := RttStat{Number: a.Number}
+	if stat.Number == 0 {
+		return stat
+	}
+	avg := a.Sum / float64(stat.Number)
+	variance := a.SumSquare/float64(stat.Number) - avg*avg
+	if variance < 0 {
+		variance = 0
+	}
+	stat.Min = a.Min
+	stat.Max = a.Max
+	stat.Avg = time.Duration(avg)
+	stat.Mdev = time.Duration(math.Sqrt(variance))
+	indexList := make([]int, 0, len(a.Buckets))
+	for index := range a.Buckets {
+		indexList = append(indexList, index)
+	}
+	sort.Ints(indexList)
+	stat.P50 = a.percentile(indexList, 50)
+	stat.P90 = a.percentile(indexList, 90)
+	stat.P99 = a.percentile(indexList, 99)
+	return stat
+}
+
+// percentile 最近秩法求分位数，indexList为从小到大排序的桶下标，取第ceil(p%*n)个时延所在桶的平均值
+func (a RttAgg) percentile(indexList []int, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(a.Number)))
+	if rank < 1 {
+		rank = 1
+	}
+	number := 0
+	for _, index := range indexList {
+		bucket := a.Buckets[index]
+		number += bucket.Number
+		if number >= rank {
+			value := bucket.Sum / time.Duration(bucket.Number)
+			if value < a.Min {
+				return a.Min
+			}
+			if value > a.Max {
+				return a.Max
+			}
+			return value
+		}
+	}
+	return a.Max
+}
+
+// rttBucketIndex 时延所在桶的下标，小于64微秒每微秒一个桶，之后每个2的幂区间64个桶
+func rttBucketIndex(rtt time.Duration) int {
+	us := rtt.Microseconds()
+	if us < 1<<rttBucketSubBits {
+		if us < 0 {
+			return 0
+		}
+		return int(us)
+	}
+	shift := bits.Len64(uint64(us)) - rttBucketSubBits - 1
+	return shift<<rttBucketSubBits + int(us>>shift)
+}
+
+// MinAvgMaxMdev 展示用，单位毫秒，没有时延时展示-
+func (s RttStat) MinAvgMaxMdev() string {
+	if s.Number == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", durationMs(s.Min), durationMs(s.Avg), durationMs(s.Max), durationMs(s.Mdev))
+}
+
+// Percentiles 展示用，单位毫秒，没有时延时展示-
+func (s RttStat) Percentiles() string {
+	if s.Number == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s/%s/%s", durationMs(s.P50), durationMs(s.P90), durationMs(s.P99))
+}
+
+// HttpTimingStat http打流每个阶段的平均耗时
+type HttpTimingStat struct {
+	Number   int           // 耗时个数，即成功数
+	Dns      time.Duration // 域名解析
+	Connect  time.Duration // tcp建连
+	Tls      time.Duration // TLS握手
+	Ttfb     time.Duration // 拿到连接到收到响应第一个字节
+	Transfer time.Duration // 收到响应第一个字节到读完响应体
+}
+
+// HttpTimingAgg http打流各阶段耗时的累计值
+type HttpTimingAgg struct {
+	Number int             // 耗时个数
+	Sum    ping.HttpTiming // 每个阶段耗时的总和
+}
+
+// Add 记录一次请求各阶段的耗时
+func (a *HttpTimingAgg) Add(timing ping.HttpTiming) {
+	a.Number++
+	a.Sum.Dns += timing.Dns
+	a.Sum.Connect += timing.Connect
+	a.Sum.Tls += timing.Tls
+	a.Sum.Ttfb += timing.Ttfb
+	a.Sum.Transfer += timing.Transfer
+}
+
+// Stat 统计每个阶段的平均耗时
+func (a HttpTimingAgg) Stat() HttpTimingStat {
+	stat := HttpTimingStat{Number: a.Number}
+	if stat.Number == 0 {
+		return stat
+	}
+	number := time.Duration(stat.Number)
+	stat.Dns = a.Sum.Dns / number
+	stat.Connect = a.Sum.Connect / number
+	stat.Tls = a.Sum.Tls / number
+	stat.Ttfb = a.Sum.Ttfb / number
+	stat.Transfer = a.Sum.Transfer / number
+	return stat
+}
+
+// String 展示用，dns/connect/tls/ttfb/transfer，单位毫秒，没有耗时时展示-
+func (s HttpTimingStat) String() string {
+	if s.Number == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s/%s/%s/%s/%s", durationMs(s.Dns), durationMs(s.Connect), durationMs(s.Tls), durationMs(s.Ttfb), durationMs(s.Transfer))
+}
+
+// durationMs 转换为毫秒，保留3位小数
+func durationMs(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d.Microseconds())/1000)
+}
+
+// toMs 转换为毫秒，保留3位小数，json输出使用
+func toMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,147 @@
+package task
+
+import (
+	"go_ping/ping"
+	"testing"
+	"time"
+)
+
+// newTestRttAgg 依次记录时延
+func newTestRttAgg(rttList ...time.Duration) RttAgg {
+	var agg RttAgg
+	for _, rtt := range rttList {
+		agg.Add(rtt)
+	}
+	return agg
+}
+
+func TestRttAggStat(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		rttList []time.Duration
+		want    RttStat
+	}{
+		{"没有时延", nil, RttStat{}},
+		{"1个", []time.Duration{5 * ms}, RttStat{Number: 1, Min: 5 * ms, Avg: 5 * ms, Max: 5 * ms, P50: 5 * ms, P90: 5 * ms, P99: 5 * ms}},
+		{"乱序的4个", []time.Duration{4 * ms, 1 * ms, 3 * ms, 2 * ms}, RttStat{Number: 4, Min: 1 * ms, Avg: 2500 * time.Microsecond, Max: 4 * ms, Mdev: 1118033 * time.Nanosecond, P50: 2 * ms, P90: 4 * ms, P99: 4 * ms}},
+		{"相同的3个", []time.Duration{2 * ms, 2 * ms, 2 * ms}, RttStat{Number: 3, Min: 2 * ms, Avg: 2 * ms, Max: 2 * ms, P50: 2 * ms, P90: 2 * ms, P99: 2 * ms}},
+		{"同一个桶取平均值", []time.Duration{1000 * time.Microsecond, 1002 * time.Microsecond}, RttStat{Number: 2, Min: 1000 * time.Microsecond, Avg: 1001 * time.Microsecond, Max: 1002 * time.Microsecond, Mdev: 1 * time.Microsecond, P50: 1001 * time.Microsecond, P90: 1001 * time.Microsecond, P99: 1001 * time.Microsecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newTestRttAgg(tt.rttList...).Stat()
+			// 标准差有浮点误差，精确到微秒
+			if got.Mdev.Round(time.Microsecond) != tt.want.Mdev.Round(time.Microsecond) {
+				t.Errorf("Stat().Mdev = %v, want %v", got.Mdev, tt.want.Mdev)
+			}
+			got.Mdev, tt.want.Mdev = 0, 0
+			if got != tt.want {
+				t.Errorf("Stat() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRttAggPercentile(t *testing.T) {
+	// 1到100毫秒，每个时延在不同的桶里，分位数是精确的
+	var hundred []time.Duration
+	for i := 1; i <= 100; i++ {
+		hundred = append(hundred, time.Duration(i)*time.Millisecond)
+	}
+	// 1微秒到100毫秒，同一个桶里有多个时延，分位数是近似的
+	var dense []time.Duration
+	for i := 1; i <= 100000; i++ {
+		dense = append(dense, time.Duration(i)*time.Microsecond)
+	}
+	tests := []struct {
+		name    string
+		rttList []time.Duration
+		wantP50 time.Duration
+		wantP90 time.Duration
+		wantP99 time.Duration
+	}{
+		{"100个", hundred, 50 * time.Millisecond, 90 * time.Millisecond, 99 * time.Millisecond},
+		{"2个p50取前一个", hundred[:2], 1 * time.Millisecond, 2 * time.Millisecond, 2 * time.Millisecond},
+		{"10个", hundred[:10], 5 * time.Millisecond, 9 * time.Millisecond, 10 * time.Millisecond},
+		{"10万个", dense, 50 * time.Millisecond, 90 * time.Millisecond, 99 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newTestRttAgg(tt.rttList...).Stat()
+			for _, c := range []struct {
+				name string
+				got  time.Duration
+				want time.Duration
+			}{{"P50", got.P50, tt.wantP50}, {"P90", got.P90, tt.wantP90}, {"P99", got.P99, tt.wantP99}} {
+				// 相对误差不超过1/64
+				if diff := c.got - c.want; diff > c.want/64 || -diff > c.want/64 {
+					t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRttAggBounded(t *testing.T) {
+	// 桶的个数只和时延的范围有关，不随探测次数增长
+	var agg RttAgg
+	for i := 0; i < 1000000; i++ {
+		agg.Add(time.Duration(i%3000) * time.Millisecond)
+	}
+	if len(agg.Buckets) > 1000 {
+		t.Errorf("len(Buckets) = %d, want <= 1000", len(agg.Buckets))
+	}
+}
+
+func TestRttBucketIndex(t *testing.T) {
+	// 下标随时延单调递增，且相邻的2的幂区间是连续的
+	last := -1
+	for us := int64(0); us < 1<<20; us++ {
+		index := rttBucketIndex(time.Duration(us) * time.Microsecond)
+		if index != last && index != last+1 {
+			t.Fatalf("rttBucketIndex(%dus) = %d, last = %d", us, index, last)
+		}
+		last = index
+	}
+	if got := rttBucketIndex(-time.Millisecond); got != 0 {
+		t.Errorf("rttBucketIndex(-1ms) = %d, want 0", got)
+	}
+}
+
+func TestHttpTimingAggStat(t *testing.T) {
+	ms := time.Millisecond
+	var agg HttpTimingAgg
+	if got := agg.Stat(); got != (HttpTimingStat{}) {
+		t.Errorf("empty Stat() = %+v", got)
+	}
+	agg.Add(ping.HttpTiming{Dns: 1 * ms, Connect: 2 * ms, Tls: 0, Ttfb: 10 * ms, Transfer: 1 * ms})
+	agg.Add(ping.HttpTiming{Dns: 3 * ms, Connect: 4 * ms, Tls: 6 * ms, Ttfb: 20 * ms, Transfer: 3 * ms})
+	want := HttpTimingStat{Number: 2, Dns: 2 * ms, Connect: 3 * ms, Tls: 3 * ms, Ttfb: 15 * ms, Transfer: 2 * ms}
+	if got := agg.Stat(); got != want {
+		t.Errorf("Stat() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRttStatString(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name              string
+		stat              RttStat
+		wantMinAvgMaxMdev string
+		wantPercentiles   string
+	}{
+		{"没有时延", RttStat{}, "-", "-"},
+		{"有时延", newTestRttAgg(1*ms, 3*ms).Stat(), "1.000/2.000/3.000/1.000", "1.000/3.000/3.000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stat.MinAvgMaxMdev(); got != tt.wantMinAvgMaxMdev {
+				t.Errorf("MinAvgMaxMdev() = %q, want %q", got, tt.wantMinAvgMaxMdev)
+			}
+			if got := tt.stat.Percentiles(); got != tt.wantPercentiles {
+				t.Errorf("Percentiles() = %q, want %q", got, tt.wantPercentiles)
+			}
+		})
+	}
+}
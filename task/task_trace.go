@@ -0,0 +1,404 @@
+// Package task 本包提供逐跳探测（traceroute），按TTL从1开始递增发包，根据路由器返回的TTL超时找出到目标经过的每一跳
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go_ping/ping"
+	"go_ping/utils"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// traceOutputMutex 多个目标同时完成时，保证每个目标的输出完整
+var traceOutputMutex sync.Mutex
+
+// TraceProbe 一次逐跳探测的结果
+type TraceProbe struct {
+	Router  string        // 回复的地址，没有收到回复时为空
+	Rtt     time.Duration // 发包到收到回复的时间
+	Reached bool          // 是否是目标的回复
+	Reason  string        // 没有收到回复时为timeout，路由器返回目标不可达等差错时为对应的原因
+	Detail  string        // 失败详情
+}
+
+// TraceHop 一跳的探测结果
+type TraceHop struct {
+	Ttl       int          // 发包的TTL，即第几跳
+	ProbeList []TraceProbe // 这一跳每次探测的结果
+}
+
+// TraceResult 一个目标的逐跳探测结果
+type TraceResult struct {
+	Target  string     // 目标，与文件或者-d里的一致
+	Ip      string     // 目标解析后的IP
+	Port    int        // tcp逐跳探测的目标端口，其他协议为0
+	Reached bool       // 是否到达了目标
+	Error   string     // 目标解析失败等错误，没有错误时为空
+	HopList []TraceHop // 每一跳的结果，到达目标或者收到目标不可达时结束
+}
+
+// tracePending 等待回复的探测，收包函数按key找到后把结果发到Reply
+type tracePending struct {
+	SendTime time.Time
+	Reply    chan TraceProbe // 缓冲为1，收包函数不会阻塞
+}
+
+// traceTarget 一个目标的探测信息，同一个目标的探测在一个goroutine里依次发出
+type traceTarget struct {
+	Target     string
+	Dst        *net.IPAddr
+	Port       int          // tcp探测的目标端口
+	IcmpId     int          // icmp探测的echo ID，每个目标不同
+	TcpSrcPort int          // tcp探测的源端口，每个目标不同
+	UdpConn    *net.UDPConn // udp探测的连接，源端口由内核分配
+	UdpSrcPort int
+	seq        int // 每次探测递增，作为echo的序号和udp的目标端口
+}
+
+// tracer 多个目标共用的逐跳探测器，共用原始套接字收发，按key把回复分发给等待的探测
+type tracer struct {
+	paramInput  ParamInput
+	handle      net.PacketConn
+	handleV6    net.PacketConn
+	tcpHandle   *net.IPConn
+	tcpHandleV6 *net.IPConn
+	pendingMap  sync.Map // key为ping.TraceIcmpKey等，value为*tracePending
+	targetNum   int32    // 已创建的目标数，用于分配echo ID和tcp源端口
+	idBase      int
+}
+
+// newTracer 创建逐跳探测器并启动收包，proto不是tcp时tcpHandle、tcpHandleV6为nil
+func newTracer(paramInput ParamInput, ctx context.Context, handle net.PacketConn, handleV6 net.PacketConn, tcpHandle *net.IPConn, tcpHandleV6 *net.IPConn) *tracer {
+	t := &tracer{
+		paramInput:  paramInput,
+		handle:      handle,
+		handleV6:    handleV6,
+		tcpHandle:   tcpHandle,
+		tcpHandleV6: tcpHandleV6,
+		idBase:      rand.Intn(utils.MaxIcmpNum + 1),
+	}
+	// 路由器的TTL超时、目标不可达都是icmp报文，三种协议都要收
+	go t.receive(ctx, handle, false, false)
+	go t.receive(ctx, handleV6, true, false)
+	if tcpHandle != nil {
+		go t.receive(ctx, tcpHandle, false, true)
+		go t.receive(ctx, tcpHandleV6, true, true)
+	}
+	return t
+}
+
+// receive 收包并分发给等待的探测，tcp为true时c是tcp原始套接字，连接关闭或者ctx取消时退出
+func (t *tracer) receive(ctx context.Context, c net.PacketConn, v6 bool, tcp bool) {
+	reply := make([]byte, 1500)
+	oob := make([]byte, 128)
+	for {
+		if err := c.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			utils.Log.Errorln("SetReadDeadline error: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		n, peer, meta, err := ping.ReadPacket(c, reply, oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if netErr, ok := err.(*net.OpError); ok && netErr.Timeout() {
+				continue
+			}
+			utils.Log.Errorln("ReadFrom error:", err)
+			continue
+		}
+		if peer == nil {
+			continue
+		}
+		router := peer.(*net.IPAddr).IP.String()
+		var traceReply ping.TraceReply
+		var ok bool
+		if tcp {
+			traceReply, ok = ping.ParseTraceTcp(reply[:n])
+		} else {
+			traceReply, ok = ping.ParseTraceIcmp(reply[:n], v6, router)
+		}
+		if !ok {
+			continue
+		}
+		value, exists := t.pendingMap.LoadAndDelete(traceReply.Key)
+		if !exists {
+			continue
+		}
+		pending := value.(*tracePending)
+		pending.Reply <- TraceProbe{
+			Router:  router,
+			Rtt:     meta.ReceiveTime.Sub(pending.SendTime),
+			Reached: traceReply.Reached,
+			Reason:  traceReply.Reason,
+			Detail:  traceReply.Detail,
+		}
+	}
+}
+
+// newTraceTarget 解析目标并分配探测使用的ID、端口，udp探测还要创建连接
+func (t *tracer) newTraceTarget(item TaskItem) (*traceTarget, error) {
+	netType := "ip4"
+	if strings.Contains(item.DstTarget, ":") {
+		netType = "ip6"
+	}
+	dst, err := net.ResolveIPAddr(netType, item.DstTarget)
+	if err != nil {
+		utils.Log.Errorln("目标地址出错", err)
+		return nil, err
+	}
+	index := int(atomic.AddInt32(&t.targetNum, 1))
+	target := &traceTarget{
+		Target:     item.DstTarget,
+		Dst:        dst,
+		Port:       item.DstPort,
+		IcmpId:     (t.idBase + index) & utils.MaxIcmpNum,
+		TcpSrcPort: utils.TcpSynSrcPortMin + (t.idBase+index)%utils.TcpSynSrcPortNum,
+		seq:        rand.Intn(utils.MaxIcmpNum + 1),
+	}
+	if t.paramInput.TraceProto == utils.TraceProtoUdp {
+		var laddr *net.UDPAddr
+		if t.paramInput.SrcIp != "" {
+			laddr = &net.UDPAddr{IP: net.ParseIP(t.paramInput.SrcIp)}
+		}
+		udpNetType := "udp4"
+		if dst.IP.To4() == nil {
+			udpNetType = "udp6"
+		}
+		target.UdpConn, err = net.ListenUDP(udpNetType, laddr)
+		if err != nil {
+			utils.Log.Errorln("创建udp连接出错", err)
+			return nil, err
+		}
+		target.UdpSrcPort = target.UdpConn.LocalAddr().(*net.UDPAddr).Port
+	}
+	return target, nil
+}
+
+// send 按协议发送一个TTL为ttl的探测，返回探测的key
+func (t *tracer) send(target *traceTarget, ttl int) (string, *tracePending, error) {
+	target.seq++
+	pending := &tracePending{Reply: make(chan TraceProbe, 1)}
+	var key string
+	var sendFunc func() error
+	switch t.paramInput.TraceProto {
+	case utils.TraceProtoUdp:
+		dstPort := utils.TraceUdpPortBase + target.seq%utils.TraceUdpPortNum
+		key = ping.TraceUdpKey(target.UdpSrcPort, dstPort)
+		sendFunc = func() error {
+			return ping.TraceUdpSend(target.UdpConn, target.Dst, dstPort, ttl)
+		}
+	case utils.TraceProtoTcp:
+		seq := rand.Uint32()
+		key = ping.TraceTcpKey(target.TcpSrcPort, seq)
+		sendFunc = func() error {
+			dst, packet, err := ping.GenTcpSynPacket(target.Dst.String(), target.Port, t.paramInput.SrcIp, target.TcpSrcPort, seq)
+			if err != nil {
+				return err
+			}
+			return ping.TraceTcpSend(dst, packet, t.tcpHandle, t.tcpHandleV6, ttl)
+		}
+	default:
+		icmpSeq := target.seq & utils.MaxIcmpNum
+		key = ping.TraceIcmpKey(target.IcmpId, icmpSeq)
+		sendFunc = func() error {
+			return ping.TraceIcmpSend(target.Dst, t.handle, t.handleV6, target.IcmpId, icmpSeq, ttl)
+		}
+	}
+	pending.SendTime = time.Now()
+	t.pendingMap.Store(key, pending)
+	if err := sendFunc(); err != nil {
+		t.pendingMap.Delete(key)
+		return key, nil, err
+	}
+	return key, pending, nil
+}
+
+// probeHop 向目标发送queries个TTL为ttl的探测，等待回复直到超时
+func (t *tracer) probeHop(target *traceTarget, ttl int, queries int) TraceHop {
+	ttlList := make([]int, queries)
+	for i := range ttlList {
+		ttlList[i] = ttl
+	}
+	return TraceHop{Ttl: ttl, ProbeList: t.probe(target, ttlList)}
+}
+
+// probe 依次发出ttlList里每个TTL的探测，再一起等待回复直到超时，返回的结果和ttlList一一对应
+func (t *tracer) probe(target *traceTarget, ttlList []int) []TraceProbe {
+	probeList := make([]TraceProbe, len(ttlList))
+	keyList := make([]string, len(ttlList))
+	pendingList := make([]*tracePending, len(ttlList))
+	for i, ttl := range ttlList {
+		key, pending, err := t.send(target, ttl)
+		if err != nil {
+			reason := ping.ClassifyError(err)
+			if reason == "" {
+				reason = ping.FailReasonOther
+			}
+			probeList[i] = TraceProbe{Reason: reason, Detail: err.Error()}
+			continue
+		}
+		keyList[i] = key
+		pendingList[i] = pending
+	}
+	deadline := time.After(time.Duration(t.paramInput.Timeout) * time.Second)
+	timedOut := false
+	for i, pending := range pendingList {
+		if pending == nil {
+			continue
+		}
+		if !timedOut {
+			select {
+			case probeList[i] = <-pending.Reply:
+				continue
+			case <-deadline:
+				timedOut = true
+			}
+		}
+		// 超时以后剩下的探测都不再等待，收包函数已经取走的探测一定会收到结果
+		if _, exists := t.pendingMap.LoadAndDelete(keyList[i]); exists {
+			probeList[i] = TraceProbe{Reason: ping.FailReasonTimeout}
+		} else {
+			probeList[i] = <-pending.Reply
+		}
+	}
+	return probeList
+}
+
+// isLastHop 探测是否到达了目标，或者收到了目标不可达等差错，不用再探测更远的跳
+func isLastHop(probe TraceProbe) bool {
+	return probe.Reached || (probe.Router != "" && probe.Reason != "")
+}
+
+// trace 从TTL为1开始逐跳探测一个目标，到达目标、收到目标不可达等差错或者超过最大跳数时结束
+func (t *tracer) trace(item TaskItem) TraceResult {
+	result := TraceResult{Target: item.DstTarget, HopList: []TraceHop{}}
+	if t.paramInput.TraceProto == utils.TraceProtoTcp {
+		result.Port = item.DstPort
+	}
+	target, err := t.newTraceTarget(item)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if target.UdpConn != nil {
+		defer target.UdpConn.Close()
+	}
+	result.Ip = target.Dst.IP.String()
+	for ttl := 1; ttl <= t.paramInput.TraceMaxHops; ttl++ {
+		hop := t.probeHop(target, ttl, t.paramInput.TraceQueries)
+		result.HopList = append(result.HopList, hop)
+		stop := false
+		for _, probe := range hop.ProbeList {
+			result.Reached = result.Reached || probe.Reached
+			stop = stop || isLastHop(probe)
+		}
+		if stop {
+			break
+		}
+	}
+	return result
+}
+
+// TaskScheduleTrace 逐跳探测的任务调度，最多-c个目标同时探测，每个目标完成后输出结果；-n为0时持续逐跳探测
+// handle、handleV6为icmp原始套接字，tcp探测还需要tcpHandle、tcpHandleV6
+func TaskScheduleTrace(paramInput ParamInput, ctx context.Context, handle net.PacketConn, handleV6 net.PacketConn, tcpHandle *net.IPConn, tcpHandleV6 *net.IPConn) {
+	taskList := new([]TaskItem)
+	if paramInput.DstFile != "" { // 读取文件
+		taskList = GenTaskListByFile(paramInput)
+	} else if paramInput.DstTarget != "" { // 单个目标
+		taskList = GenTaskListBySingleTarget(paramInput)
+	}
+	if len(*taskList) == 0 {
+		fmt.Println(utils.NoTaskError)
+		os.Exit(0)
+	}
+	// 分配到多个goroutine中，每个goroutine依次探测分到的目标
+	concurrencyTask := GenConcurrencyTaskList(taskList, paramInput.Concurrency)
+	taskId := concurrencyTask.TaskId
+	t := newTracer(paramInput, ctx, handle, handleV6, tcpHandle, tcpHandleV6)
+	// 持续逐跳探测，统计每一跳的丢包和时延
+	if paramInput.Number == 0 {
+		taskScheduleMtr(paramInput, ctx, t, concurrencyTask)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, list := range concurrencyTask.RoutineTaskList {
+		wg.Add(1)
+		go func(list *RoutineTaskItem) {
+			defer wg.Done()
+			for _, item := range list.TaskItemList {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+				showTrace(t.trace(*item), taskId, paramInput)
+			}
+		}(list)
+	}
+	wg.Wait()
+}
+
+// showTrace 输出一个目标的逐跳探测结果，json、ndjson模式一个目标一行json，否则按跳输出
+func showTrace(result TraceResult, taskId string, paramInput ParamInput) {
+	traceOutputMutex.Lock()
+	defer traceOutputMutex.Unlock()
+	if paramInput.ShowMode == utils.ShowModeJson || paramInput.ShowMode == utils.ShowModeNdjson {
+		printJson(newJsonTrace(result, taskId, paramInput))
+		return
+	}
+	target := result.Target
+	if result.Port != 0 {
+		target = fmt.Sprintf("%s:%d", result.Target, result.Port)
+	}
+	if result.Error != "" {
+		fmt.Printf("traceroute %s %s，目标地址出错：%s\n\n", target, paramInput.TraceProto, result.Error)
+		return
+	}
+	fmt.Printf("traceroute %s (%s) %s，最多%d跳\n", target, result.Ip, paramInput.TraceProto, paramInput.TraceMaxHops)
+	for _, hop := range result.HopList {
+		fmt.Printf("%3d  %s\n", hop.Ttl, traceHopString(hop))
+	}
+	if !result.Reached {
+		fmt.Println("没有到达目标")
+	}
+	fmt.Println()
+}
+
+// traceHopString 一跳的结果，回复地址和上一次探测不同时才输出，没有收到回复为*，差错原因放在方括号里
+func traceHopString(hop TraceHop) string {
+	var fields []string
+	lastRouter := ""
+	for _, probe := range hop.ProbeList {
+		if probe.Router == "" {
+			if probe.Reason == ping.FailReasonTimeout {
+				fields = append(fields, "*")
+			} else {
+				fields = append(fields, fmt.Sprintf("* [%s]", probe.Reason))
+			}
+			continue
+		}
+		if probe.Router != lastRouter {
+			fields = append(fields, probe.Router)
+			lastRouter = probe.Router
+		}
+		field := fmt.Sprintf("%sms", durationMs(probe.Rtt))
+		if probe.Reason != "" {
+			field = fmt.Sprintf("%s [%s]", field, probe.Reason)
+		}
+		fields = append(fields, field)
+	}
+	return strings.Join(fields, "  ")
+}
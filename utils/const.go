@@ -4,14 +4,24 @@ var (
 	PingTypeTCP           = "tcp"
 	PingTypeICMP          = "icmp"
 	PingTypeHTTP          = "http"
-	PingTypeList          = []string{PingTypeTCP, PingTypeICMP, PingTypeHTTP}
+	PingTypeUDP           = "udp"
+	PingTypeDNS           = "dns"
+	PingTypeTLS           = "tls"
+	PingTypeTcpSyn        = "tcp-syn"
+	PingTypeTrace         = "trace"
+	PingTypeList          = []string{PingTypeTCP, PingTypeICMP, PingTypeHTTP, PingTypeUDP, PingTypeDNS, PingTypeTLS, PingTypeTcpSyn, PingTypeTrace}
 	ShowModeWaterfall     = "waterfall"
 	ShowModeTable         = "table"
 	ShowModeJson          = "json"
-	ShowModeList          = []string{ShowModeWaterfall, ShowModeTable, ShowModeJson}
+	ShowModeNdjson        = "ndjson"
+	ShowModeList          = []string{ShowModeWaterfall, ShowModeTable, ShowModeJson, ShowModeNdjson}
 	MaxIcmpNum            = 65535
 	IcmpSendIntervalMac   = 9       // 毫秒
 	IcmpSendIntervalLinux = 1       // 毫秒
+	IcmpSockAuto          = "auto"  // root权限使用原始套接字，否则使用非特权的icmp套接字
+	IcmpSockRaw           = "raw"   // 原始套接字
+	IcmpSockDgram         = "dgram" // 非特权的icmp套接字
+	IcmpSockList          = []string{IcmpSockAuto, IcmpSockRaw, IcmpSockDgram}
 	ErrorLevel            = "error" // 日志级别
 	WarnLevel             = "warn"
 	InfoLevel             = "info"
@@ -19,4 +29,36 @@ var (
 	NoTaskError           = "没有可执行的任务，请检查参数！"
 	DomainMaxLen          = 100
 	DefaultPortNumber     = 80
+	DefaultUdpPayload     = "HELLO-R-U-THERE"
+	TcpReadTimeoutLimit   = 10000  // tcp等待响应的最大超时时间，单位毫秒
+	TcpSynSrcPortMin      = 20000  // tcp-syn打流使用的源端口范围，避开内核的临时端口
+	TcpSynSrcPortNum      = 10000  // tcp-syn打流使用的源端口个数
+	IcmpSizeMin           = 33     // icmp发包内容最少的字节数，即8字节发送时间戳、2字节ID、4字节进程随机数、4字节轮次加15字节固定内容
+	IcmpSizeLimit         = 65507  // icmp发包内容最多的字节数，即65535减去ip头和icmp头
+	IcmpTtlLimit          = 255    // icmp TTL的最大值
+	IcmpTosLimit          = 255    // icmp TOS的最大值
+	HexPayloadPrefix      = "hex:" // 以此开头的发包内容按十六进制解析
+	HttpSchemeList        = []string{"http", "https"}
+	HttpMethodList        = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	HttpRedirectList      = []string{"follow", "none", "fail"}
+	HttpRedirectMaxLimit  = 100
+	HttpConnNew           = "new"       // 每次探测新建连接
+	HttpConnKeepAlive     = "keepalive" // 每个协程复用长连接
+	HttpConnList          = []string{HttpConnNew, HttpConnKeepAlive}
+	DefaultDnsPort        = 53
+	DefaultTlsPort        = 443
+	TlsWarnDaysLimit      = 3650
+	DnsTypeList           = []string{"A", "AAAA", "CNAME", "MX", "TXT", "SRV"}
+	DnsProtoList          = []string{"udp", "tcp"}
+	TraceProtoIcmp        = "icmp"
+	TraceProtoUdp         = "udp"
+	TraceProtoTcp         = "tcp"
+	TraceProtoList        = []string{TraceProtoIcmp, TraceProtoUdp, TraceProtoTcp}
+	TraceMaxHopsLimit     = 255   // 逐跳探测的最大跳数
+	TraceQueriesLimit     = 10    // 逐跳探测每一跳最多的探测次数
+	TraceUdpPortBase      = 33434 // 逐跳探测udp使用的目标端口范围，和传统traceroute一致
+	TraceUdpPortNum       = 1000  // 逐跳探测udp使用的目标端口个数
+	TraceReprobeRounds    = 10    // 持续逐跳探测每隔多少轮探测到最大跳数，发现变长的路径
+	HopChangeConfirmNum   = 2     // 连续估算到相同的新跳数的次数，达到后才算跳数变化，避免偶尔一个回复走了别的路径
+	HopExpireRounds       = 10    // 持续打流时目标连续多少轮没有估算跳数后清除，目标不再回复时不再占用内存
 )
@@ -1,10 +1,13 @@
 package utils
 
 import (
+	"encoding/hex"
 	"fmt"
 	"github.com/asaskevich/govalidator"
 	"net"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -23,6 +26,14 @@ func ValidateParams(params map[string]string) {
 				os.Exit(0)
 			}
 		case "dst.target":
+			// http打流可以使用完整的url
+			if ValidateHttpUrl(value) {
+				if params["ping.type"] != PingTypeHTTP {
+					fmt.Println("目的信息格式错误，只有http打流可以使用url")
+					os.Exit(0)
+				}
+				continue
+			}
 			// 如果是ip，就通过，如果不是ip，再继续校验
 			if !ValidateIP(value) {
 				// 如果是域名，就通过，如果不是域名，再继续校验
@@ -97,11 +108,181 @@ func ValidateParams(params map[string]string) {
 					os.Exit(0)
 				}
 			}
+		case "tcp.send":
+			if _, err := ParsePayload(value); err != nil {
+				fmt.Println("tcp发送内容格式错误")
+				os.Exit(0)
+			}
+		case "tcp.expect":
+			if _, err := ParsePayload(value); err != nil {
+				fmt.Println("tcp期望的响应格式错误")
+				os.Exit(0)
+			}
+		case "tcp.expect.regex":
+			if _, err := regexp.Compile(value); err != nil {
+				fmt.Println("tcp期望的响应正则格式错误")
+				os.Exit(0)
+			}
+		case "tcp.read.timeout":
+			if !govalidator.IsNumeric(value) {
+				fmt.Println("tcp等待响应超时时间格式错误")
+				os.Exit(0)
+			}
+			valueInt, _ := strconv.Atoi(value)
+			if valueInt < 0 || valueInt > TcpReadTimeoutLimit {
+				fmt.Println("tcp等待响应超时时间格式错误")
+				os.Exit(0)
+			}
+		case "icmp.size":
+			if !govalidator.IsNumeric(value) {
+				fmt.Println("icmp发包大小格式错误")
+				os.Exit(0)
+			}
+			valueInt, _ := strconv.Atoi(value)
+			if valueInt != 0 && (valueInt < IcmpSizeMin || valueInt > IcmpSizeLimit) {
+				fmt.Println("icmp发包大小格式错误")
+				os.Exit(0)
+			}
+		case "icmp.pattern":
+			if content, err := ParsePayload(value); err != nil || len(content) == 0 {
+				fmt.Println("icmp填充内容格式错误")
+				os.Exit(0)
+			}
+		case "icmp.ttl":
+			if !govalidator.IsNumeric(value) {
+				fmt.Println("icmp TTL格式错误")
+				os.Exit(0)
+			}
+			valueInt, _ := strconv.Atoi(value)
+			if valueInt < 0 || valueInt > IcmpTtlLimit {
+				fmt.Println("icmp TTL格式错误")
+				os.Exit(0)
+			}
+		case "icmp.tos":
+			if !govalidator.IsNumeric(value) {
+				fmt.Println("icmp TOS格式错误")
+				os.Exit(0)
+			}
+			valueInt, _ := strconv.Atoi(value)
+			if valueInt < 0 || valueInt > IcmpTosLimit {
+				fmt.Println("icmp TOS格式错误")
+				os.Exit(0)
+			}
+		case "icmp.sock":
+			if !ContainsString(IcmpSockList, value) {
+				fmt.Println("icmp套接字类型格式错误")
+				os.Exit(0)
+			}
+		case "trace.proto":
+			if !ContainsString(TraceProtoList, value) {
+				fmt.Println("逐跳探测协议格式错误")
+				os.Exit(0)
+			}
+		case "trace.max.hops":
+			if !govalidator.IsNumeric(value) {
+				fmt.Println("逐跳探测最大跳数格式错误")
+				os.Exit(0)
+			}
+			valueInt, _ := strconv.Atoi(value)
+			if valueInt < 1 || valueInt > TraceMaxHopsLimit {
+				fmt.Println("逐跳探测最大跳数格式错误")
+				os.Exit(0)
+			}
+		case "trace.queries":
+			if !govalidator.IsNumeric(value) {
+				fmt.Println("逐跳探测每跳探测次数格式错误")
+				os.Exit(0)
+			}
+			valueInt, _ := strconv.Atoi(value)
+			if valueInt < 1 || valueInt > TraceQueriesLimit {
+				fmt.Println("逐跳探测每跳探测次数格式错误")
+				os.Exit(0)
+			}
+		case "udp.payload":
+			if _, err := ParsePayload(value); err != nil {
+				fmt.Println("udp发包内容格式错误")
+				os.Exit(0)
+			}
+		case "dns.name":
+			if !ValidateDomain(value) || len(value) > DomainMaxLen {
+				fmt.Println("dns查询域名格式错误")
+				os.Exit(0)
+			}
+		case "dns.type":
+			if !ContainsString(DnsTypeList, value) {
+				fmt.Println("dns查询记录类型格式错误")
+				os.Exit(0)
+			}
+		case "dns.proto":
+			if !ContainsString(DnsProtoList, value) {
+				fmt.Println("dns查询协议格式错误")
+				os.Exit(0)
+			}
+		case "http.scheme":
+			if !ContainsString(HttpSchemeList, value) {
+				fmt.Println("http协议格式错误")
+				os.Exit(0)
+			}
+		case "http.method":
+			if !ContainsString(HttpMethodList, strings.ToUpper(value)) {
+				fmt.Println("http请求方法格式错误")
+				os.Exit(0)
+			}
+		case "http.request.body.file":
+			if !FileExists(value) {
+				fmt.Println("http请求体文件不存在")
+				os.Exit(0)
+			}
+		case "http.redirect":
+			if !ContainsString(HttpRedirectList, value) {
+				fmt.Println("http跳转策略格式错误")
+				os.Exit(0)
+			}
+		case "http.redirect.max":
+			if !govalidator.IsNumeric(value) {
+				fmt.Println("http最多跳转次数格式错误")
+				os.Exit(0)
+			}
+			valueInt, _ := strconv.Atoi(value)
+			if valueInt < 1 || valueInt > HttpRedirectMaxLimit {
+				fmt.Println("http最多跳转次数格式错误")
+				os.Exit(0)
+			}
+		case "http.conn":
+			if !ContainsString(HttpConnList, value) {
+				fmt.Println("http连接模式格式错误")
+				os.Exit(0)
+			}
+		case "tls.warn.days":
+			if !govalidator.IsNumeric(value) {
+				fmt.Println("证书过期告警天数格式错误")
+				os.Exit(0)
+			}
+			valueInt, _ := strconv.Atoi(value)
+			if valueInt < 0 || valueInt > TlsWarnDaysLimit {
+				fmt.Println("证书过期告警天数格式错误")
+				os.Exit(0)
+			}
+		case "tls.ca.file":
+			if !FileExists(value) {
+				fmt.Println("CA证书文件不存在")
+				os.Exit(0)
+			}
 		case "show.mode":
 			if !ContainsString(ShowModeList, value) {
 				fmt.Println("展示模式格式错误")
 				os.Exit(0)
 			}
+		case "show.top":
+			if !govalidator.IsNumeric(value) {
+				fmt.Println("展示目标数格式错误")
+				os.Exit(0)
+			}
+			valueInt, _ := strconv.Atoi(value)
+			if valueInt < 0 || valueInt > 100000 {
+				fmt.Println("展示目标数格式错误")
+				os.Exit(0)
+			}
 		case "log.level":
 			if value != ErrorLevel && value != WarnLevel && value != InfoLevel && value != DebugLevel {
 				fmt.Println("日志级别格式错误")
@@ -134,6 +315,33 @@ func ValidateIP(ipStr string) bool {
 	return true
 }
 
+// ValidateHttpUrl 校验是否是http或https开头的完整url
+func ValidateHttpUrl(rawUrl string) bool {
+	if !strings.HasPrefix(rawUrl, "http://") && !strings.HasPrefix(rawUrl, "https://") {
+		return false
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	return u.Hostname() != ""
+}
+
+// HttpUrlPort 获取url的端口，没有指定端口时使用协议的默认端口
+func HttpUrlPort(rawUrl string) int {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return 0
+	}
+	if port, err1 := strconv.Atoi(u.Port()); err1 == nil {
+		return port
+	}
+	if u.Scheme == "https" {
+		return 443
+	}
+	return 80
+}
+
 func ValidateDomain(domain string) bool {
 	return govalidator.IsDNSName(domain)
 }
@@ -150,9 +358,49 @@ func FileExists(filename string) bool {
 	return false
 }
 
-func GenIcmpIdAndSeq(num int) (int, int) {
+// GenIcmpIdAndSeq 第num个icmp任务的ID和序号，ID从随机的idBase开始往下递减，避免同一台机器上的多个go_ping使用相同的ID
+func GenIcmpIdAndSeq(idBase int, num int) (int, int) {
 	id := num / MaxIcmpNum
 	seq := num % MaxIcmpNum
-	id = MaxIcmpNum - id
+	id = (idBase - id) & MaxIcmpNum
 	return id, seq
 }
+
+// ParsePayload 解析发包内容，以hex:开头的按十六进制解析，否则支持\r、\n、\t、\\、\xNN转义，其他内容原样发送
+func ParsePayload(payload string) ([]byte, error) {
+	if strings.HasPrefix(payload, HexPayloadPrefix) {
+		return hex.DecodeString(strings.TrimPrefix(payload, HexPayloadPrefix))
+	}
+	var content []byte
+	for i := 0; i < len(payload); i++ {
+		if payload[i] != '\\' || i+1 >= len(payload) {
+			content = append(content, payload[i])
+			continue
+		}
+		switch payload[i+1] {
+		case 'r':
+			content = append(content, '\r')
+		case 'n':
+			content = append(content, '\n')
+		case 't':
+			content = append(content, '\t')
+		case '\\':
+			content = append(content, '\\')
+		case 'x':
+			if i+3 >= len(payload) {
+				return nil, fmt.Errorf("转义格式错误：%s", payload[i:])
+			}
+			value, err := hex.DecodeString(payload[i+2 : i+4])
+			if err != nil {
+				return nil, fmt.Errorf("转义格式错误：%s", payload[i:i+4])
+			}
+			content = append(content, value...)
+			i += 2
+		default:
+			// 不支持的转义原样保留
+			content = append(content, payload[i], payload[i+1])
+		}
+		i++
+	}
+	return content, nil
+}